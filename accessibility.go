@@ -0,0 +1,274 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Conformance statements for Accessibility.ConformsTo.
+const (
+	ConformsWCAG20A  = "EPUB Accessibility 1.1 - WCAG 2.0 Level A"
+	ConformsWCAG20AA = "EPUB Accessibility 1.1 - WCAG 2.0 Level AA"
+	ConformsWCAG21A  = "EPUB Accessibility 1.1 - WCAG 2.1 Level A"
+	ConformsWCAG21AA = "EPUB Accessibility 1.1 - WCAG 2.1 Level AA"
+	ConformsWCAG22AA = "EPUB Accessibility 1.1 - WCAG 2.2 Level AA"
+)
+
+const (
+	nsXML = "http://www.w3.org/XML/1998/namespace"
+	nsOPS = "http://www.idpf.org/2007/ops"
+)
+
+var (
+	imgTagRegex  = regexp.MustCompile(`<img\b[^>]*>`)
+	altAttrRegex = regexp.MustCompile(`\balt="([^"]*)"`)
+	srcAttrRegex = regexp.MustCompile(`\bsrc="([^"]*)"`)
+)
+
+// Accessibility is the EPUB Accessibility 1.1 profile of a book.
+// The values are the schema.org vocabulary, e.g. AccessModes
+// "textual" and "visual", Features "structuralNavigation" and
+// "alternativeText", and Hazards "none".
+type Accessibility struct {
+	// AccessModes are detected from the book when left empty.
+	AccessModes []string
+	// AccessModesSufficient are comma separated sets of access
+	// modes that are enough to read the whole book, like
+	// "textual" or "textual,visual".
+	AccessModesSufficient []string
+	Features              []string
+	Hazards               []string
+	Summary               string
+	// ConformsTo is one of the Conforms constants.
+	ConformsTo string
+	// CertifiedBy is who evaluated the conformance. (Optional)
+	CertifiedBy string
+}
+
+// SetAccessibility sets the accessibility metadata written to
+// content.opf. Use CheckAccessibility to find the images and
+// documents that would break the conformance claim.
+func (e *Book) SetAccessibility(a Accessibility) {
+	e.args.Accessibility = &a
+}
+
+// DescribeImage sets the alt text for an image added with AddImage.
+// Any <img> pointing at the image with an empty alt gets the
+// description when the book is built.
+func (e *Book) DescribeImage(imageFilename, description string) {
+	e.imageAlt[imageFilename] = description
+}
+
+// accessModes returns the access modes the book needs: textual,
+// plus visual when it has images.
+func (e *Book) accessModes() []string {
+	modes := []string{"textual"}
+	for _, f := range e.args.Files {
+		if strings.HasPrefix(f.MediaType, "image/") {
+			return append(modes, "visual")
+		}
+	}
+	return modes
+}
+
+// describeImages fills in the empty alt attributes of images that
+// were given a description with DescribeImage.
+func (e *Book) describeImages(content string) string {
+	if len(e.imageAlt) == 0 {
+		return content
+	}
+	alts := make(map[string]string)
+	for name, alt := range e.imageAlt {
+		if p, ok := e.imageLookup[name]; ok {
+			alts[p] = alt
+		}
+	}
+	return imgTagRegex.ReplaceAllStringFunc(content, func(img string) string {
+		src := srcAttrRegex.FindStringSubmatch(img)
+		if src == nil {
+			return img
+		}
+		alt, ok := alts[src[1]]
+		if !ok {
+			return img
+		}
+		escaped := xmlEscape(alt)
+		if m := altAttrRegex.FindStringSubmatch(img); m != nil {
+			if strings.TrimSpace(m[1]) != "" {
+				return img
+			}
+			return strings.Replace(img, m[0], `alt="`+escaped+`"`, 1)
+		}
+		return strings.Replace(img, "<img", `<img alt="`+escaped+`"`, 1)
+	})
+}
+
+func xmlEscape(s string) string {
+	b := &bytes.Buffer{}
+	xml.EscapeText(b, []byte(s))
+	return b.String()
+}
+
+// CheckAccessibility builds the book and checks it with the same
+// rules as CheckAccessibilityReader.
+func (e *Book) CheckAccessibility() []ValidationIssue {
+	buf := &bytes.Buffer{}
+	if _, err := e.WriteTo(buf); err != nil {
+		return []ValidationIssue{{
+			Severity: SeverityError,
+			Message:  err.Error(),
+		}}
+	}
+	issues, err := CheckAccessibilityReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		return []ValidationIssue{{
+			Severity: SeverityError,
+			Message:  err.Error(),
+		}}
+	}
+	return issues
+}
+
+// CheckAccessibilityReader checks an epub of the given size read
+// from r against EPUB Accessibility 1.1: the accessibility metadata,
+// alt text on every image, a lang on every document, and a nav
+// landmarks list.
+func CheckAccessibilityReader(r io.ReaderAt, size int64) ([]ValidationIssue, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "Read epub")
+	}
+	v := &validator{
+		docs: make(map[string]*validatedDoc),
+	}
+	a, err := openArchive(zr)
+	if err != nil {
+		v.add(SeverityError, "", 0, err.Error())
+		return v.issues, nil
+	}
+	v.epubArchive = a
+	v.checkAccessibilityMetadata()
+	v.checkAccessibilityDocuments()
+	return v.issues, nil
+}
+
+func (v *validator) checkAccessibilityMetadata() {
+	found := make(map[string]bool)
+	for _, m := range v.pkg.Metadata.Metas {
+		if m.Refines == "" && strings.TrimSpace(m.Value) != "" {
+			found[m.Property] = true
+		}
+	}
+	for _, property := range []string{
+		"schema:accessMode",
+		"schema:accessModeSufficient",
+		"schema:accessibilityFeature",
+		"schema:accessibilityHazard",
+		"schema:accessibilitySummary",
+		"dcterms:conformsTo",
+	} {
+		if !found[property] {
+			v.add(SeverityWarning, v.opfPath, lineOf(v.opf, "<metadata"), "metadata is missing %s", property)
+		}
+	}
+}
+
+func (v *validator) checkAccessibilityDocuments() {
+	paths := []string{}
+	for _, item := range v.pkg.Manifest {
+		if item.MediaType == mtXHTML {
+			paths = append(paths, v.itemPath(item))
+		}
+	}
+	sort.Strings(paths)
+
+	landmarks := -1
+	for _, p := range paths {
+		b, err := v.read(p)
+		if err != nil {
+			continue
+		}
+		if n := v.checkAccessibilityDocument(p, b); n >= 0 {
+			landmarks = n
+		}
+	}
+	switch landmarks {
+	case -1:
+		v.add(SeverityWarning, "", 0, "nav has no landmarks")
+	case 0:
+		v.add(SeverityWarning, "", 0, "nav landmarks are empty")
+	}
+}
+
+// checkAccessibilityDocument checks the lang and image alt text of
+// one document. It returns the number of landmarks if the document
+// has a landmarks nav, or -1.
+func (v *validator) checkAccessibilityDocument(p string, b []byte) int {
+	landmarks := -1
+	depth, inLandmarks := 0, 0
+	d := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		offset := d.InputOffset()
+		tok, err := d.Token()
+		if err != nil {
+			// well-formedness is Validate's job
+			break
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			if inLandmarks == depth {
+				inLandmarks = 0
+			}
+			depth--
+		case xml.StartElement:
+			depth++
+			line := lineAt(b, offset)
+			switch t.Name.Local {
+			case "html":
+				if xmlAttr(t, "", "lang") == "" && xmlAttr(t, nsXML, "lang") == "" {
+					v.add(SeverityError, p, line, "<html> has no lang attribute")
+				}
+			case "img":
+				alt, ok := xmlAttrOK(t, "", "alt")
+				decorative := xmlAttr(t, "", "role") == "presentation"
+				switch {
+				case !ok:
+					v.add(SeverityError, p, line, "<img> %s has no alt text", xmlAttr(t, "", "src"))
+				case strings.TrimSpace(alt) == "" && !decorative:
+					v.add(SeverityError, p, line, "<img> %s has empty alt text, describe it with DescribeImage or mark it role=\"presentation\"", xmlAttr(t, "", "src"))
+				}
+			case "nav":
+				if hasProperty(xmlAttr(t, nsOPS, "type"), "landmarks") {
+					inLandmarks = depth
+					landmarks = 0
+				}
+			case "a":
+				if inLandmarks > 0 {
+					landmarks++
+				}
+			}
+		}
+	}
+	return landmarks
+}
+
+func xmlAttr(t xml.StartElement, space, local string) string {
+	value, _ := xmlAttrOK(t, space, local)
+	return value
+}
+
+func xmlAttrOK(t xml.StartElement, space, local string) (string, bool) {
+	for _, a := range t.Attr {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,143 @@
+package epub
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// accessibleBook returns the files of testBook with the metadata,
+// lang attributes, and landmarks the accessibility checks want.
+func accessibleBook() map[string]string {
+	files := testBook()
+	files["OEBPS/content.opf"] = strings.Replace(testOPF, "  </metadata>", `    <meta property="schema:accessMode">textual</meta>
+    <meta property="schema:accessModeSufficient">textual</meta>
+    <meta property="schema:accessibilityFeature">structuralNavigation</meta>
+    <meta property="schema:accessibilityHazard">none</meta>
+    <meta property="schema:accessibilitySummary">Fully accessible.</meta>
+    <meta property="dcterms:conformsTo">`+ConformsWCAG21AA+`</meta>
+  </metadata>`, 1)
+	for name, doc := range files {
+		files[name] = strings.Replace(doc, `<html xmlns="http://www.w3.org/1999/xhtml"`, `<html xmlns="http://www.w3.org/1999/xhtml" lang="en"`, 1)
+	}
+	files["OEBPS/nav.xhtml"] = strings.Replace(files["OEBPS/nav.xhtml"], "</nav>", `</nav>
+<nav epub:type="landmarks"><ol><li><a epub:type="bodymatter" href="ch01.xhtml">Begin Reading</a></li></ol></nav>`, 1)
+	return files
+}
+
+func TestCheckAccessibilityReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(files map[string]string)
+		want   []string
+	}{
+		{
+			name:   "accessible",
+			change: func(map[string]string) {},
+		},
+		{
+			name: "missing metadata",
+			change: func(files map[string]string) {
+				files["OEBPS/content.opf"] = strings.Replace(files["OEBPS/content.opf"], "<meta property=\"schema:accessibilitySummary\">Fully accessible.</meta>", "", 1)
+			},
+			want: []string{"metadata is missing schema:accessibilitySummary"},
+		},
+		{
+			name: "missing lang",
+			change: func(files map[string]string) {
+				files["OEBPS/ch02.xhtml"] = strings.Replace(files["OEBPS/ch02.xhtml"], ` lang="en"`, "", 1)
+			},
+			want: []string{"OEBPS/ch02.xhtml:2: <html> has no lang attribute"},
+		},
+		{
+			name: "xml:lang",
+			change: func(files map[string]string) {
+				files["OEBPS/ch02.xhtml"] = strings.Replace(files["OEBPS/ch02.xhtml"], ` lang="en"`, ` xml:lang="en"`, 1)
+			},
+		},
+		{
+			name: "images",
+			change: func(files map[string]string) {
+				files["OEBPS/ch02.xhtml"] = strings.Replace(files["OEBPS/ch02.xhtml"], "The note.", `<img src="a.png"/>
+<img src="b.png" alt=" "/>
+<img src="c.png" alt="" role="presentation"/>
+<img src="d.png" alt="A map"/>`, 1)
+			},
+			want: []string{
+				"OEBPS/ch02.xhtml:4: <img> a.png has no alt text",
+				"OEBPS/ch02.xhtml:5: <img> b.png has empty alt text",
+			},
+		},
+		{
+			name: "no landmarks",
+			change: func(files map[string]string) {
+				files["OEBPS/nav.xhtml"] = strings.Replace(files["OEBPS/nav.xhtml"], `epub:type="landmarks"`, `epub:type="page-list"`, 1)
+			},
+			want: []string{"nav has no landmarks"},
+		},
+		{
+			name: "empty landmarks",
+			change: func(files map[string]string) {
+				files["OEBPS/nav.xhtml"] = strings.Replace(files["OEBPS/nav.xhtml"], `<li><a epub:type="bodymatter" href="ch01.xhtml">Begin Reading</a></li>`, "", 1)
+			},
+			want: []string{"nav landmarks are empty"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := accessibleBook()
+			tt.change(files)
+			b := zipBook(t, files)
+			issues, err := CheckAccessibilityReader(bytes.NewReader(b), int64(len(b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, issue := range issues {
+				got = append(got, issue.String())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Got issues:\n%s\nwant %q", strings.Join(got, "\n"), tt.want)
+			}
+			for i := range got {
+				if !strings.Contains(got[i], tt.want[i]) {
+					t.Errorf("Got %q, want %q", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckAccessibility(t *testing.T) {
+	e := NewBook("Test Book")
+	e.SetAuthor("Test Author")
+	e.SetAccessibility(Accessibility{
+		AccessModesSufficient: []string{"textual"},
+		Features:              []string{"structuralNavigation", "alternativeText"},
+		Hazards:               []string{"none"},
+		Summary:               "Images are described & the text is navigable.",
+		ConformsTo:            ConformsWCAG21AA,
+	})
+	must(t, e.AddImage("testdata/gophercolor16x16.png", "gopher.png"))
+	must(t, e.AddChapterMD("One", "![](gopher.png)\n\nText."))
+	issues := e.CheckAccessibility()
+	if len(issues) != 1 || !strings.Contains(issues[0].Message, "has empty alt text") {
+		t.Fatalf("Got issues %v, want the undescribed image", issues)
+	}
+
+	e.DescribeImage("gopher.png", `A "gopher" & friends`)
+	for _, issue := range e.CheckAccessibility() {
+		t.Error(issue)
+	}
+	opf := documents(t, buildValid(t, e))["OEBPS/content.opf"]
+	for _, want := range []string{
+		`<meta property="schema:accessMode">textual</meta>`,
+		`<meta property="schema:accessMode">visual</meta>`,
+		`<meta property="schema:accessibilitySummary">Images are described &amp; the text is navigable.</meta>`,
+	} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("content.opf is missing %s", want)
+		}
+	}
+	wantText(t, buildValid(t, e), []string{`alt="A &#34;gopher&#34; &amp; friends"`})
+}
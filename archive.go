@@ -0,0 +1,162 @@
+package epub
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// opfPackage is the subset of content.opf needed to read or
+// finalize a book.
+type opfPackage struct {
+	UniqueIdentifier string      `xml:"unique-identifier,attr"`
+	Metadata         opfMetadata `xml:"metadata"`
+	Manifest         []opfItem   `xml:"manifest>item"`
+	Spine            opfSpine    `xml:"spine"`
+}
+type opfMetadata struct {
+	Identifiers  []opfElement `xml:"identifier"`
+	Titles       []opfElement `xml:"title"`
+	Creators     []opfElement `xml:"creator"`
+	Contributors []opfElement `xml:"contributor"`
+	Descriptions []opfElement `xml:"description"`
+	Publishers   []opfElement `xml:"publisher"`
+	Dates        []opfElement `xml:"date"`
+	Languages    []opfElement `xml:"language"`
+	Rights       []opfElement `xml:"rights"`
+	Subjects     []opfElement `xml:"subject"`
+	Metas        []opfMeta    `xml:"meta"`
+}
+type opfElement struct {
+	ID    string `xml:"id,attr"`
+	Value string `xml:",chardata"`
+
+	// epub2 creator attributes
+	Role   string `xml:"role,attr"`
+	FileAs string `xml:"file-as,attr"`
+}
+type opfMeta struct {
+	ID       string `xml:"id,attr"`
+	Name     string `xml:"name,attr"`
+	Content  string `xml:"content,attr"`
+	Property string `xml:"property,attr"`
+	Refines  string `xml:"refines,attr"`
+	Value    string `xml:",chardata"`
+}
+type opfItem struct {
+	ID           string `xml:"id,attr"`
+	Href         string `xml:"href,attr"`
+	MediaType    string `xml:"media-type,attr"`
+	Properties   string `xml:"properties,attr"`
+	Fallback     string `xml:"fallback,attr"`
+	MediaOverlay string `xml:"media-overlay,attr"`
+}
+type opfSpine struct {
+	Toc             string       `xml:"toc,attr"`
+	PageProgression string       `xml:"page-progression-direction,attr"`
+	Itemrefs        []opfItemref `xml:"itemref"`
+}
+type opfItemref struct {
+	IDRef  string `xml:"idref,attr"`
+	Linear string `xml:"linear,attr"`
+}
+
+type opfContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// epubArchive is an epub zip with its package document parsed.
+type epubArchive struct {
+	files map[string]*zip.File
+
+	opfPath string
+	opf     []byte
+	pkg     opfPackage
+}
+
+func openArchive(src *zip.Reader) (*epubArchive, error) {
+	a := &epubArchive{
+		files: make(map[string]*zip.File),
+	}
+	for _, zf := range src.File {
+		a.files[zf.Name] = zf
+	}
+
+	b, err := a.read("META-INF/container.xml")
+	if err != nil {
+		return nil, err
+	}
+	c := opfContainer{}
+	if err := xml.Unmarshal(b, &c); err != nil {
+		return nil, errors.Wrap(err, "Parse container.xml")
+	}
+	if len(c.Rootfiles) == 0 {
+		return nil, errors.New("container.xml has no rootfile")
+	}
+	a.opfPath = c.Rootfiles[0].FullPath
+	a.opf, err = a.read(a.opfPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := xml.Unmarshal(a.opf, &a.pkg); err != nil {
+		return nil, errors.Wrap(err, "Parse "+a.opfPath)
+	}
+	return a, nil
+}
+
+func (a *epubArchive) read(name string) ([]byte, error) {
+	zf, ok := a.files[name]
+	if !ok {
+		return nil, errors.Errorf("Missing file in epub: %s", name)
+	}
+	r, err := zf.Open()
+	if err != nil {
+		return nil, errors.Wrap(err, "Open "+name)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// itemPath returns the path inside the archive for a manifest item.
+func (a *epubArchive) itemPath(item opfItem) string {
+	return resolveRef(a.opfPath, item.Href)
+}
+
+// item returns the manifest item with the given id.
+func (a *epubArchive) item(id string) (opfItem, bool) {
+	for _, item := range a.pkg.Manifest {
+		if item.ID == id {
+			return item, true
+		}
+	}
+	return opfItem{}, false
+}
+
+// coverID returns the manifest id of the cover image, from the
+// cover-image property or the epub2 cover meta. Older books put
+// the image's path in the meta instead of its id.
+func (a *epubArchive) coverID() string {
+	for _, item := range a.pkg.Manifest {
+		if hasProperty(item.Properties, "cover-image") {
+			return item.ID
+		}
+	}
+	for _, m := range a.pkg.Metadata.Metas {
+		if m.Name != "cover" {
+			continue
+		}
+		if _, ok := a.item(m.Content); ok {
+			return m.Content
+		}
+		for _, item := range a.pkg.Manifest {
+			if a.itemPath(item) == m.Content {
+				return item.ID
+			}
+		}
+	}
+	return ""
+}
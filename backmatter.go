@@ -0,0 +1,164 @@
+package epub
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// RelatedBook is a book listed on the "Also by" page.
+type RelatedBook struct {
+	Title string
+	// Series groups the book with the others in the series
+	// (Optional)
+	Series      string
+	SeriesIndex float64
+	// Link is where to buy or read about the book (Optional)
+	Link string
+}
+
+// AlsoByOptions holds the books listed by AddAlsoBy.
+type AlsoByOptions struct {
+	// Title defaults to "Also by <Author>"
+	Title string
+	Books []RelatedBook
+}
+
+type alsoByPage struct {
+	Groups []relatedGroup
+}
+type relatedGroup struct {
+	Series string
+	Books  []RelatedBook
+}
+
+// AddAlsoBy adds an "Also by" page using the alsoby.xhtml template.
+// Books in a series are grouped under the series name in series
+// order, and the groups keep the order their first book was given
+// in. Set the author first, or give a Title.
+func (e *Book) AddAlsoBy(opts AlsoByOptions) error {
+	if len(opts.Books) == 0 {
+		return errors.New("Also by page needs at least one book")
+	}
+	if opts.Title == "" {
+		opts.Title = "Also by " + e.args.Author
+		if e.args.Author == "" {
+			opts.Title = "Also by the Author"
+		}
+	}
+
+	page := &alsoByPage{}
+	groups := make(map[string]int)
+	for _, b := range opts.Books {
+		i, ok := groups[b.Series]
+		if !ok || b.Series == "" {
+			page.Groups = append(page.Groups, relatedGroup{Series: b.Series})
+			i = len(page.Groups) - 1
+			groups[b.Series] = i
+		}
+		page.Groups[i].Books = append(page.Groups[i].Books, b)
+	}
+	for _, g := range page.Groups {
+		sort.SliceStable(g.Books, func(i, j int) bool {
+			return g.Books[i].SeriesIndex < g.Books[j].SeriesIndex
+		})
+	}
+
+	return e.addSection(2, epubSection{
+		title:       opts.Title,
+		parts:       []string{""},
+		template:    "alsoby.xhtml",
+		sectionType: "also-by",
+		page:        page,
+	})
+}
+
+// AboutAuthorOptions holds the content of the about the author page.
+type AboutAuthorOptions struct {
+	// Title defaults to "About the Author"
+	Title string
+	// Bio is markdown
+	Bio string
+	// Image is the path to a photo of the author (Optional)
+	Image string
+	// Website is linked below the bio (Optional)
+	Website string
+}
+
+type aboutAuthorPage struct {
+	Image   string
+	Website string
+}
+
+// AddAboutAuthor adds an about the author page using the
+// aboutauthor.xhtml template.
+func (e *Book) AddAboutAuthor(opts AboutAuthorOptions) error {
+	if opts.Title == "" {
+		opts.Title = "About the Author"
+	}
+	content, err := e.renderMarkdownPage(opts.Bio)
+	if err != nil {
+		return err
+	}
+	page := &aboutAuthorPage{Website: opts.Website}
+	if opts.Image != "" {
+		name := "about-author" + filepath.Ext(opts.Image)
+		if err := e.AddImage(opts.Image, name); err != nil {
+			return err
+		}
+		page.Image, _ = e.LookupImage(name)
+	}
+	return e.addSection(2, epubSection{
+		title:       opts.Title,
+		parts:       []string{content},
+		template:    "aboutauthor.xhtml",
+		sectionType: "about-author",
+		page:        page,
+	})
+}
+
+// NewsletterOptions holds the content of the newsletter sign-up page.
+type NewsletterOptions struct {
+	// Title defaults to "Join the Newsletter"
+	Title string
+	// Text is markdown shown above the link
+	Text string
+	// Link is the sign-up page
+	Link string
+	// LinkText defaults to "Sign Up"
+	LinkText string
+}
+
+type newsletterPage struct {
+	Link     string
+	LinkText string
+}
+
+// AddNewsletter adds a call to action page linking to a newsletter
+// sign-up, using the newsletter.xhtml template.
+func (e *Book) AddNewsletter(opts NewsletterOptions) error {
+	if opts.Link == "" {
+		return errors.New("Newsletter page needs a link")
+	}
+	if opts.Title == "" {
+		opts.Title = "Join the Newsletter"
+	}
+	if opts.LinkText == "" {
+		opts.LinkText = "Sign Up"
+	}
+	content, err := e.renderMarkdownPage(opts.Text)
+	if err != nil {
+		return err
+	}
+	return e.addSection(2, epubSection{
+		title:       opts.Title,
+		parts:       []string{content},
+		template:    "newsletter.xhtml",
+		sectionType: "newsletter",
+		page: &newsletterPage{
+			Link:     opts.Link,
+			LinkText: opts.LinkText,
+		},
+	})
+}
@@ -0,0 +1,74 @@
+package epub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAlsoBy(t *testing.T) {
+	e := NewBook("Test Book")
+	e.SetAuthor("Smith & Wesson")
+	if err := e.AddAlsoBy(AlsoByOptions{}); err == nil {
+		t.Error("AddAlsoBy accepted an empty list")
+	}
+	must(t, e.AddChapterMD("One", "Text."))
+	must(t, e.AddAlsoBy(AlsoByOptions{Books: []RelatedBook{
+		{Title: "Salt & Pepper", Series: "Kitchen <Tales>", SeriesIndex: 2, Link: "https://example.com/?a=1&b=2"},
+		{Title: "Standalone"},
+		{Title: "Flour", Series: "Kitchen <Tales>", SeriesIndex: 1},
+	}}))
+	b := buildValid(t, e)
+	wantText(t, b, []string{
+		"Also by Smith &amp; Wesson",
+		`<h2 class="cahaba--also-by-series">Kitchen &lt;Tales&gt;</h2>`,
+		`<a href="https://example.com/?a=1&amp;b=2">Salt &amp; Pepper</a>`,
+	})
+
+	// the series is grouped in series order, before the book given
+	// after its first one
+	for name, doc := range documents(t, b) {
+		if !strings.HasSuffix(name, ".xhtml") || !strings.Contains(doc, "cahaba--also-by-list") {
+			continue
+		}
+		flour, salt, alone := strings.Index(doc, "Flour"), strings.Index(doc, "Salt &amp;"), strings.Index(doc, "Standalone")
+		if !(flour < salt && salt < alone) {
+			t.Errorf("Also by books are out of order:\n%s", doc)
+		}
+	}
+}
+
+func TestAboutAuthor(t *testing.T) {
+	e := NewBook("Test Book")
+	e.SetAuthor(`Ed "Jr" <Smith>`)
+	must(t, e.AddChapterMD("One", "Text."))
+	must(t, e.AddAboutAuthor(AboutAuthorOptions{
+		Bio:     "Ed writes about *salt* & pepper.",
+		Image:   "testdata/gophercolor16x16.png",
+		Website: "https://example.com/?a=1&b=2",
+	}))
+	wantText(t, buildValid(t, e), []string{
+		"About the Author",
+		"<em>salt</em> &amp; pepper",
+		`alt="Ed &#34;Jr&#34; &lt;Smith&gt;"`,
+		`<a href="https://example.com/?a=1&amp;b=2">https://example.com/?a=1&amp;b=2</a>`,
+	})
+}
+
+func TestNewsletter(t *testing.T) {
+	e := NewBook("Test Book")
+	if err := e.AddNewsletter(NewsletterOptions{}); err == nil {
+		t.Error("AddNewsletter accepted a page without a link")
+	}
+	must(t, e.AddChapterMD("One", "Text."))
+	must(t, e.AddNewsletter(NewsletterOptions{
+		Title:    "News & Updates",
+		Text:     "Hear about new books first.",
+		Link:     "https://example.com/join?list=1&src=epub",
+		LinkText: "Sign <up>",
+	}))
+	wantText(t, buildValid(t, e), []string{
+		"News &amp; Updates",
+		"Hear about new books first.",
+		`<a href="https://example.com/join?list=1&amp;src=epub">Sign &lt;up&gt;</a>`,
+	})
+}
@@ -0,0 +1,282 @@
+package epub
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// GeneratedCover is how the cover is drawn for books without one.
+// The title goes at the top, with the series under it, and the
+// authors at the bottom.
+type GeneratedCover struct {
+	// Background is the image the text is drawn on. Empty uses
+	// the built-in "No Cover Provided" image.
+	Background string
+	// Font is the filename of a TrueType or OpenType font added
+	// with AddAsset. Empty uses Go Bold for the title and Go
+	// Regular for the rest.
+	Font string
+	// Color is the color of the text, black when nil.
+	Color color.Color
+	// Disabled leaves books without a cover instead, saving the
+	// time and space drawing one takes.
+	Disabled bool
+}
+
+// The smallest generated cover, which clears the retailer minimums
+// checked by Validate.
+const (
+	generatedCoverWidth  = 1600
+	generatedCoverHeight = 2400
+)
+
+// SetGeneratedCover sets how the cover is drawn when SetCover is
+// never called, so drafts and review copies always have a cover,
+// or turns the generated cover off.
+func (e *Book) SetGeneratedCover(opts GeneratedCover) error {
+	var bg []byte
+	if opts.Background != "" {
+		b, err := os.ReadFile(opts.Background)
+		if err != nil {
+			return errors.Wrap(err, "Cover background")
+		}
+		if _, _, err := image.DecodeConfig(bytes.NewReader(b)); err != nil {
+			return errors.Wrapf(err, "Cover background %s", opts.Background)
+		}
+		bg = b
+	}
+	e.Lock()
+	e.generatedCover = opts
+	e.coverBackground = bg
+	e.Unlock()
+	return nil
+}
+
+// isFontType reports whether an asset is a font that can be drawn
+// with, so it is kept for generated covers.
+func isFontType(mediaType string) bool {
+	switch mediaType {
+	case "font/ttf", "font/otf", "font/sfnt", "application/font-sfnt",
+		"application/x-font-ttf", "application/x-font-truetype",
+		"application/x-font-opentype", "application/vnd.ms-opentype":
+		return true
+	}
+	return false
+}
+
+// generateCover draws the title, series, and authors onto the
+// cover background and adds it as the cover. The caller must hold
+// the lock.
+func (e *Book) generateCover() error {
+	bg := e.coverBackground
+	if bg == nil {
+		b, err := tmpl.ReadFile("tmpl/defaultcover.png")
+		if err != nil {
+			return err
+		}
+		bg = b
+	}
+	src, format, err := image.Decode(bytes.NewReader(bg))
+	if err != nil {
+		return errors.Wrap(err, "Cover background")
+	}
+
+	titleFont, err := e.coverFont(gobold.TTF)
+	if err != nil {
+		return err
+	}
+	textFont, err := e.coverFont(goregular.TTF)
+	if err != nil {
+		return err
+	}
+
+	// small backgrounds are scaled up to the size retailers ask
+	// for, keeping their shape
+	bounds := src.Bounds()
+	scale := math.Max(
+		float64(generatedCoverWidth)/float64(bounds.Dx()),
+		float64(generatedCoverHeight)/float64(bounds.Dy()),
+	)
+	scale = math.Max(scale, 1)
+	dst := image.NewRGBA(image.Rect(
+		0, 0,
+		int(math.Ceil(float64(bounds.Dx())*scale)),
+		int(math.Ceil(float64(bounds.Dy())*scale)),
+	))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	c := e.generatedCover.Color
+	if c == nil {
+		c = color.Black
+	}
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(c)}
+
+	w, h := dst.Bounds().Dx(), dst.Bounds().Dy()
+	maxWidth := w * 8 / 10
+	// the title and series share the top of the cover, above the
+	// built-in image's text, and the authors take the bottom
+	top, band := h*6/100, h*20/100
+	series := e.args.Series
+	if series != "" && e.args.SeriesIndex != "" {
+		series += ", Book " + e.args.SeriesIndex
+	}
+	titleBand := band
+	if series != "" {
+		titleBand = band * 3 / 4
+	}
+	y := drawLines(d, titleFont, e.args.Title, w, maxWidth, titleBand, h/10, top)
+	if series != "" {
+		drawLines(d, textFont, series, w, maxWidth, top+band-y, h/28, y+h/100)
+	}
+	drawLines(d, textFont, e.args.Author, w, maxWidth, h*12/100, h/18, h*80/100)
+
+	out := &bytes.Buffer{}
+	filename, mediaType := "cover.png", "image/png"
+	if format == "jpeg" {
+		filename, mediaType = "cover.jpg", "image/jpeg"
+		err = jpeg.Encode(out, dst, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(out, dst)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Encode generated cover")
+	}
+	if _, err := e.storeImage(out, filename, mediaType); err != nil {
+		return err
+	}
+	e.markCover(filename)
+	return nil
+}
+
+// coverFont returns the font asset chosen for the generated cover,
+// or the fallback when none was.
+func (e *Book) coverFont(fallback []byte) (*opentype.Font, error) {
+	b := fallback
+	if name := e.generatedCover.Font; name != "" {
+		var ok bool
+		if b, ok = e.fonts[name]; !ok {
+			return nil, errors.Errorf("Cover font not found, add it with AddAsset: %s", name)
+		}
+	}
+	f, err := opentype.Parse(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cover font %s", e.generatedCover.Font)
+	}
+	return f, nil
+}
+
+// drawLines draws text centered across a width wide image,
+// starting at top. It wraps the text to maxWidth and shrinks it
+// from size until it fits in height. It returns where the text
+// ends.
+func drawLines(d *font.Drawer, f *opentype.Font, text string, width, maxWidth, height int, size int, top int) int {
+	if text == "" {
+		return top
+	}
+	var lines []string
+	var lineHeight int
+	for ; ; size = size * 9 / 10 {
+		face, err := opentype.NewFace(f, &opentype.FaceOptions{
+			Size:    float64(size),
+			DPI:     72,
+			Hinting: font.HintingFull,
+		})
+		if err != nil {
+			return top
+		}
+		d.Face = face
+		lines = wrapText(d, text, maxWidth)
+		lineHeight = face.Metrics().Height.Ceil()
+		fits := lineHeight*len(lines) <= height
+		for _, line := range lines {
+			if d.MeasureString(line).Ceil() > maxWidth {
+				fits = false
+			}
+		}
+		if fits || size <= 8 {
+			break
+		}
+	}
+
+	ascent := d.Face.Metrics().Ascent.Ceil()
+	for _, line := range lines {
+		x := (width - d.MeasureString(line).Ceil()) / 2
+		d.Dot = fixed.P(x, top+ascent)
+		d.DrawString(line)
+		top += lineHeight
+	}
+	return top
+}
+
+// wrapText splits text into lines no wider than maxWidth, except
+// for single words that are wider on their own.
+func wrapText(d *font.Drawer, text string, maxWidth int) []string {
+	lines := []string{}
+	line := ""
+	for _, word := range strings.Fields(text) {
+		next := word
+		if line != "" {
+			next = line + " " + word
+		}
+		if line != "" && d.MeasureString(next).Ceil() > maxWidth {
+			lines = append(lines, line)
+			next = word
+		}
+		line = next
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// coverMediaTypes are the cover formats every retailer accepts.
+var coverMediaTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+// checkCover checks that a cover image is a JPEG or PNG, or will
+// be converted to one, and returns its media type.
+func (e *Book) checkCover(b []byte, mediaType string) (string, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err == nil {
+		detected := "image/" + format
+		if mediaType != "" && mediaType != detected {
+			return "", errors.Errorf("File is a %s image, not %s", format, mediaType)
+		}
+		mediaType = detected
+	}
+	if coverMediaTypes[mediaType] {
+		if err != nil {
+			return "", errors.Wrap(err, "Read image")
+		}
+		return mediaType, nil
+	}
+
+	e.Lock()
+	allowed := profileTypes[e.imageOpts.Profile]
+	e.Unlock()
+	if allowed != nil && !allowed[mediaType] && mediaType != "" && mediaType != "image/svg+xml" {
+		// converted to a JPEG or PNG as it's added
+		return mediaType, nil
+	}
+	if mediaType == "" {
+		mediaType = "unknown"
+	}
+	return "", errors.Errorf("Unsupported format %s, covers must be JPEG or PNG", mediaType)
+}
@@ -0,0 +1,130 @@
+package epub
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGeneratedCover(t *testing.T) {
+	e := NewBook("Salt & Pepper")
+	e.SetAuthor("Test Author")
+	e.SetSeries("Kitchen Tales", 2)
+	must(t, e.AddChapterMD("One", "Text."))
+	b := buildValid(t, e)
+
+	docs := documents(t, b)
+	opf := docs["OEBPS/content.opf"]
+	for _, want := range []string{
+		`href="images/img_cover.png" media-type="image/png" properties="cover-image"/>`,
+		`<meta name="cover" content="img_cover.png" />`,
+		`<itemref idref="cover.xhtml"/>`,
+	} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("content.opf is missing %s", want)
+		}
+	}
+	cfg, _, err := image.DecodeConfig(strings.NewReader(readZipFile(t, readEpub(t, b), "OEBPS/images/img_cover.png")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width < generatedCoverWidth || cfg.Height < generatedCoverHeight {
+		t.Errorf("Generated a %dx%d cover", cfg.Width, cfg.Height)
+	}
+	if err := e.SetGeneratedCover(GeneratedCover{Background: "testdata/cover.css"}); err == nil {
+		t.Error("SetGeneratedCover accepted a background that isn't an image")
+	}
+}
+
+func TestGeneratedCoverDisabled(t *testing.T) {
+	e := NewBook("Test Book")
+	must(t, e.SetGeneratedCover(GeneratedCover{Disabled: true}))
+	must(t, e.AddChapterMD("One", "Text."))
+	b := buildValid(t, e)
+
+	for name, doc := range documents(t, b) {
+		for _, s := range []string{"cover.xhtml", "cover-image", `name="cover"`, ">Cover<"} {
+			if strings.Contains(doc, s) {
+				t.Errorf("%s has %s without a cover", name, s)
+			}
+		}
+	}
+	for _, zf := range readEpub(t, b).File {
+		if strings.Contains(zf.Name, "cover") {
+			t.Errorf("Wrote %s without a cover", zf.Name)
+		}
+	}
+
+	opened, err := OpenReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantText(t, buildValid(t, opened), []string{"Text."})
+}
+
+func TestCheckCover(t *testing.T) {
+	g := &bytes.Buffer{}
+	must(t, gif.Encode(g, image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black}), nil))
+	tests := []struct {
+		name      string
+		profile   CompatibilityProfile
+		b         []byte
+		mediaType string
+		want      string
+		err       string
+	}{
+		{name: "png", b: testPNGBytes(t), mediaType: "image/png", want: "image/png"},
+		{name: "detected", b: testPNGBytes(t), want: "image/png"},
+		{name: "wrong extension", b: testPNGBytes(t), mediaType: "image/jpeg", err: "File is a png image, not image/jpeg"},
+		{name: "gif", b: g.Bytes(), mediaType: "image/gif", err: "Unsupported format image/gif"},
+		{name: "gif allowed by profile", profile: ProfileCompatible, b: g.Bytes(), err: "Unsupported format image/gif"},
+		{name: "converted by profile", profile: ProfileCompatible, b: []byte("RIFF"), mediaType: "image/webp", want: "image/webp"},
+		{name: "svg", profile: ProfileCompatible, b: []byte("<svg/>"), mediaType: "image/svg+xml", err: "Unsupported format image/svg+xml"},
+		{name: "broken", b: []byte("not an image"), mediaType: "image/png", err: "Read image"},
+		{name: "unknown", b: []byte("not an image"), err: "Unsupported format unknown"},
+	}
+	for _, tt := range tests {
+		e := NewBook("Test Book")
+		must(t, e.SetImageOptions(ImageOptions{Profile: tt.profile}))
+		got, err := e.checkCover(tt.b, tt.mediaType)
+		switch {
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		case tt.err == "" && (err != nil || got != tt.want):
+			t.Errorf("%s: got %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestSetCoverTwice(t *testing.T) {
+	jpg := filepath.Join(t.TempDir(), "cover.jpg")
+	out := &bytes.Buffer{}
+	must(t, jpeg.Encode(out, image.NewGray(image.Rect(0, 0, 16, 24)), nil))
+	must(t, os.WriteFile(jpg, out.Bytes(), 0o644))
+
+	e := NewBook("Test Book")
+	must(t, e.SetCover("testdata/gophercolor16x16.png"))
+	must(t, e.SetCover("testdata/gophercolor16x16.png"))
+	must(t, e.SetCover(jpg))
+	must(t, e.AddChapterMD("One", "Text."))
+	b := buildValid(t, e)
+
+	opf := documents(t, b)["OEBPS/content.opf"]
+	if n := strings.Count(opf, `properties="cover-image"`); n != 1 {
+		t.Errorf("content.opf has %d cover images, want 1", n)
+	}
+	if !strings.Contains(opf, `href="images/img_cover.jpg" media-type="image/jpeg" properties="cover-image"/>`) {
+		t.Errorf("The last cover isn't the cover image:\n%s", opf)
+	}
+	for _, zf := range readEpub(t, b).File {
+		if strings.HasPrefix(zf.Name, "OEBPS/images/img_cover") && zf.Name != "OEBPS/images/img_cover.jpg" {
+			t.Errorf("Replaced cover %s is still in the book", zf.Name)
+		}
+	}
+}
@@ -6,14 +6,12 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/extension"
@@ -45,8 +43,11 @@ func (e *FileRetrievalError) Error() string {
 // Epub implements an EPUB file.
 type Book struct {
 	sync.Mutex
-	file *zip.Writer
-	buf  *bytes.Buffer
+	// staged are the files added to the book, copied into the
+	// archive of every build, and file is the archive of the build
+	// in progress
+	staged []stagedFile
+	file   *zip.Writer
 
 	md   goldmark.Markdown
 	exts []goldmark.Extender
@@ -54,97 +55,168 @@ type Book struct {
 	// The key is the image filename, the value is the image source
 	imageLookup map[string]string
 	assetLookup map[string]string
+	// The key is the image filename, the value is its alt text
+	imageAlt map[string]string
+	// sourceDir is where markdown images that weren't added are
+	// looked for, and imageErrs are the ones that weren't found
+	sourceDir string
+	imageErrs []string
+	// imageOpts resizes and converts images as they are added
+	imageOpts ImageOptions
+	// generatedCover is drawn on coverBackground when no cover is
+	// set, with fonts from the added font assets
+	generatedCover  GeneratedCover
+	coverBackground []byte
+	fonts           map[string][]byte
 
 	sections [3][]epubSection
 
+	// polish runs Calibre's ebook-polish over the finished book
+	polish bool
+
+	// modified is fixed by SetModifiedTime, otherwise the time
+	// of the build is used
+	reproducible bool
+	modified     time.Time
+
+	// horizontalProgression is the page progression the book had
+	// before it was made vertical
+	horizontalProgression string
+
+	// notes places markdown footnotes, and endnotes collects
+	// them for the Notes section in notesFile
+	notes     NoteMode
+	notesFile string
+	endnotes  []endnoteGroup
+
+	// pages are the rendered section pages, kept until every
+	// link between them is resolved
+	pages []*bookPage
+
+	// toc places the table of contents, and frontPages counts the
+	// pages of the introduction sections it can be placed after
+	toc        TOCOptions
+	frontPages int
+
+	tocDepth     int
+	navPoint     int
+	sectionCount int
+
 	args *bookArgs
 }
 
 type bookArgs struct {
-	Title          string
-	Description    string
-	Stylesheet     string
-	StylesheetName string
-	CoverImage     string
-	Cover          string
-	URN            string
-	Author         string
-	Publisher      string
-	ReleaseDate    string
-	CurrentDate    string
-	Files          []bookFile
-	Sections       []bookSection
-	Chapters       []bookChapter
+	Title              string
+	Description        string
+	Stylesheet         string
+	StylesheetName     string
+	CoverImage         string
+	Cover              string
+	CoverID            string
+	URN                string
+	Identifiers        []bookIdentifier
+	Author             string
+	Publisher          string
+	ReleaseDate        string
+	CurrentDate        string
+	Language           string
+	PageProgression    string
+	Direction          string
+	WritingMode        string
+	VerticalStylesheet string
+	Rights             string
+	ISBN               string
+	Year               string
+	Subjects           []bookSubject
+	Metas              []bookMeta
+	Links              []bookLink
+	Accessibility      *Accessibility
+	Landmarks          []bookLandmark
+	Series             string
+	SeriesIndex        string
+	Creators           []bookCreator
+	Contributors       []bookCreator
+	Files              []bookFile
+	Sections           []bookSection
+	Chapters           []bookChapter
+	Depth              int
+}
+type stagedFile struct {
+	name string
+	data []byte
 }
 type bookFile struct {
 	ID         string
 	Path       string
 	MediaType  string
 	Properties string
+	// Fallback is the id of the file reading systems use in its
+	// place when they can't show it
+	Fallback string
 }
 type bookSection struct {
-	Ref string
+	Ref    string
+	Linear string
 }
 type bookChapter struct {
 	NavPoint string
 	ID       string
 	Title    string
+	Label    string
+	Subtitle string
 	Path     string
 	Type     string
+	Children []bookChapter
 }
 type epubSection struct {
 	title string
 	parts []string
+
+	// template overrides chapter.xhtml, and sectionType overrides
+	// the introduction, chapter, or postscript type
+	template    string
+	sectionType string
+	label       string
+	subtitle    string
+	// page is the data of generated pages, for their templates
+	page any
+	// sources are the paths of the documents each part was read
+	// from, when the book was opened
+	sources [][]string
 }
 
 // NewBook returns a new Epub.
 func NewBook(title string) *Book {
 	e := &Book{
 		args: &bookArgs{
-			Title:          title,
-			Stylesheet:     "../default.css",
-			StylesheetName: "default.css",
-			URN:            uuid.Must(uuid.NewV4()).String(),
-			CurrentDate:    time.Now().UTC().Format(time.RFC3339),
+			Title:           title,
+			Stylesheet:      "../default.css",
+			StylesheetName:  "default.css",
+			Language:        "en",
+			PageProgression: string(LeftToRight),
 		},
-		buf: &bytes.Buffer{},
 		exts: []goldmark.Extender{
 			extension.Table,
 			extension.Strikethrough,
 			extension.DefinitionList,
 		},
+		tocDepth: 1,
 	}
-	e.file = zip.NewWriter(e.buf)
-
-	mtf, _ := e.file.CreateHeader(&zip.FileHeader{
-		Name:   "mimetype",
-		Method: zip.Store,
-	})
-	io.WriteString(mtf, "application/epub+zip")
-
-	dsf, _ := e.file.CreateHeader(&zip.FileHeader{
-		Name:   "OEBPS/default.css",
-		Method: zip.Store,
-	})
+	e.stage("mimetype", []byte("application/epub+zip"))
 	b, _ := RetrieveTemplate("default.css")
-	if _, err := dsf.Write(b); err != nil {
-		log.Fatal("Write CSS: ", err)
-	}
+	e.stage("OEBPS/default.css", b)
 	e.args.Files = append(e.args.Files, bookFile{
 		ID:        "default.css",
 		Path:      "OEBPS/default.css",
 		MediaType: "text/css",
 	})
-
-	cont, _ := e.file.CreateHeader(&zip.FileHeader{
-		Name:   "META-INF/container.xml",
-		Method: zip.Store,
-	})
 	b, _ = RetrieveTemplate("container.xml")
-	cont.Write(b)
+	e.stage("META-INF/container.xml", b)
 
 	e.imageLookup = make(map[string]string)
 	e.assetLookup = make(map[string]string)
+	e.imageAlt = make(map[string]string)
+	e.fonts = make(map[string][]byte)
 
 	return e
 }
@@ -152,36 +224,99 @@ func NewBook(title string) *Book {
 // SetCSS will set the CSS file for the book. It is not
 // recommended to call this more than once for a book.
 func (e *Book) SetCSS(source string) error {
-	err := e.addFile("stylesheet.css", source, "text/css")
+	f, err := os.Open(source)
 	if err != nil {
 		return errors.Wrap(
 			err,
 			"Add CSS",
 		)
 	}
-	e.args.Stylesheet = "../../stylesheet.css"
+	defer f.Close()
+	return e.setCSS(f)
+}
+
+func (e *Book) setCSS(r io.Reader) error {
+	err := e.addReader("OEBPS/stylesheet.css", r, "text/css")
+	if err != nil {
+		return errors.Wrap(
+			err,
+			"Add CSS",
+		)
+	}
+	e.args.Stylesheet = "../stylesheet.css"
 	e.args.StylesheetName = "stylesheet.css"
 	return nil
 }
 
+// SetCalibrePolish controls whether Write runs Calibre's
+// ebook-polish over the finished book. The library finalizes
+// the epub itself, so this is off by default and only needed
+// for Calibre's image compression and CSS cleanup.
+func (e *Book) SetCalibrePolish(enabled bool) {
+	e.polish = enabled
+}
+
+// SetCover adds the cover image. It must be a JPEG or PNG, or a
+// format the compatibility profile converts to one.
 func (e *Book) SetCover(source string) error {
-	ext := filepath.Ext(source)
-	err := e.AddImage(source, "cover"+ext)
+	ext := strings.ToLower(filepath.Ext(source))
+	b, err := os.ReadFile(source)
 	if err != nil {
 		return err
 	}
-	e.args.Cover = "OEBPS/images/img_cover" + ext
-	e.args.CoverImage = "../images/img_cover" + ext
-
-	e.args.Files[len(e.args.Files)-1].Properties = "cover-image"
+	mediaType, err := e.checkCover(b, ImageMediaTypes[ext])
+	if err != nil {
+		return errors.Wrapf(err, "Cover %s", source)
+	}
+	if err := e.addImage(bytes.NewReader(b), "cover"+ext, mediaType); err != nil {
+		return err
+	}
+	e.markCover("cover" + ext)
 	return nil
 }
 
+// markCover flags the image that was added as filename as the
+// cover. A cover it replaces is dropped from the book.
+func (e *Book) markCover(filename string) {
+	e.args.CoverImage = e.imageLookup[filename]
+	e.args.Cover = "OEBPS/images/" + strings.TrimPrefix(e.args.CoverImage, "../images/")
+	e.args.CoverID = ""
+	files := e.args.Files[:0]
+	for _, f := range e.args.Files {
+		switch {
+		case f.Path == e.args.Cover:
+			f.Properties = "cover-image"
+			e.args.CoverID = f.ID
+		case f.Properties == "cover-image":
+			continue
+		}
+		files = append(files, f)
+	}
+	e.args.Files = files
+}
+
 func (e *Book) AddAsset(source, filename, mediaType string) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return e.addAsset(f, filename, mediaType)
+}
+
+func (e *Book) addAsset(r io.Reader, filename, mediaType string) error {
 	e.Lock()
+	defer e.Unlock()
 	e.assetLookup[filename] = "../assets/" + filename
-	e.Unlock()
-	return e.addFile("OEBPS/assets/"+filename, source, mediaType)
+	if isFontType(mediaType) {
+		font := &bytes.Buffer{}
+		if err := e.writeFile("OEBPS/assets/"+filename, io.TeeReader(r, font), mediaType); err != nil {
+			return err
+		}
+		e.fonts[filename] = font.Bytes()
+		return nil
+	}
+	return e.writeFile("OEBPS/assets/"+filename, r, mediaType)
 }
 
 var ImageMediaTypes = map[string]string{
@@ -197,18 +332,60 @@ var ImageMediaTypes = map[string]string{
 }
 
 func (e *Book) AddImage(source, filename string) error {
-	mediaType := ImageMediaTypes[filepath.Ext(source)]
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return e.addImage(f, filename, ImageMediaTypes[filepath.Ext(source)])
+}
 
-	finalName := strings.ReplaceAll(filename, "/", "_")
-	finalName = strings.ReplaceAll(finalName, " ", "_")
-	finalName = "img_" + finalName
+func (e *Book) addImage(r io.Reader, filename, mediaType string) error {
 	e.Lock()
+	defer e.Unlock()
+	_, err := e.storeImage(r, filename, mediaType)
+	return err
+}
+
+// storeImage processes an image, stores it, and returns the path
+// it can be looked up by. The caller must hold the lock.
+func (e *Book) storeImage(r io.Reader, filename, mediaType string) (string, error) {
+	r, stored, mediaType, err := e.processImage(r, filename, mediaType)
+	if err != nil {
+		return "", err
+	}
+	finalName := e.unusedFileName("OEBPS/images/", imageFileName(stored))
 	if Debug {
 		fmt.Println("Image Lookup: ", filename, "=>", finalName)
 	}
+	if err := e.writeFile("OEBPS/images/"+finalName, r, mediaType); err != nil {
+		return "", err
+	}
 	e.imageLookup[filename] = "../images/" + finalName
-	e.Unlock()
-	return e.addFile("OEBPS/images/"+finalName, source, mediaType)
+	return e.imageLookup[filename], nil
+}
+
+// unusedFileName numbers name, like img_cover-2.png, when a file
+// in dir already has it, so replaced images get their own manifest
+// id.
+func (e *Book) unusedFileName(dir, name string) string {
+	used := make(map[string]bool)
+	for _, f := range e.args.Files {
+		used[f.Path] = true
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for n := 2; used[dir+name]; n++ {
+		name = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+	return name
+}
+
+// imageFileName returns the name an image is stored under.
+func imageFileName(filename string) string {
+	finalName := strings.ReplaceAll(filename, "/", "_")
+	finalName = strings.ReplaceAll(finalName, " ", "_")
+	return "img_" + finalName
 }
 
 func (e *Book) AddImageFolder(source string) error {
@@ -222,29 +399,25 @@ func (e *Book) AddImageFolder(source string) error {
 	})
 }
 
-func (e *Book) addFile(zipPath, filePath, mediaType string) error {
-	zipPath = strings.ReplaceAll(zipPath, " ", "_")
+func (e *Book) addReader(zipPath string, r io.Reader, mediaType string) error {
 	e.Lock()
 	defer e.Unlock()
-	w, err := e.file.CreateHeader(&zip.FileHeader{
-		Name:   zipPath,
-		Method: zip.Store,
-	})
-	if err != nil {
-		return err
-	}
-	f, err := os.Open(filePath)
+	return e.writeFile(zipPath, r, mediaType)
+}
+
+// writeFile stores r in the staging archive. The caller must hold
+// the lock.
+func (e *Book) writeFile(zipPath string, r io.Reader, mediaType string) error {
+	zipPath = strings.ReplaceAll(zipPath, " ", "_")
+	b, err := io.ReadAll(r)
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(w, f)
-	if err != nil && err != io.EOF {
-		fmt.Println(err)
+	if err := e.stage(zipPath, b); err != nil {
 		return err
 	}
-	f.Close()
 	e.args.Files = append(e.args.Files, bookFile{
-		ID:        filepath.Base(zipPath),
+		ID:        e.unusedID(filepath.Base(zipPath)),
 		Path:      zipPath,
 		MediaType: mediaType,
 	})
@@ -252,6 +425,50 @@ func (e *Book) addFile(zipPath, filePath, mediaType string) error {
 	return nil
 }
 
+// unusedID returns id, numbered when a file already has it, since
+// files from different folders can share a name.
+func (e *Book) unusedID(id string) string {
+	ext := filepath.Ext(id)
+	base := strings.TrimSuffix(id, ext)
+	for n := 2; ; n++ {
+		used := false
+		for _, f := range e.args.Files {
+			if f.ID == id {
+				used = true
+				break
+			}
+		}
+		if !used {
+			return id
+		}
+		id = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+}
+
+// stage keeps a file to be copied into the archive of every build.
+// Files made during a build, like a generated cover, only go into
+// the archive of that build.
+func (e *Book) stage(name string, b []byte) error {
+	if e.file != nil {
+		return e.createFile(name, b)
+	}
+	e.staged = append(e.staged, stagedFile{name: name, data: b})
+	return nil
+}
+
+// createFile writes a file to the archive of the build in progress.
+func (e *Book) createFile(name string, b []byte) error {
+	w, err := e.file.CreateHeader(&zip.FileHeader{
+		Name:   name,
+		Method: zip.Store,
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Create %s", name))
+	}
+	_, err = w.Write(b)
+	return err
+}
+
 func (e *Book) LookupImage(imageFilename string) (string, bool) {
 	a, b := e.imageLookup[imageFilename]
 	return a, b
@@ -271,7 +488,7 @@ func (e *Book) AddIntroductionMD(title string, body string) error {
 	return e.AddIntroductionHTML(title, content)
 }
 func (e *Book) AddIntroductionHTML(title string, body []string) error {
-	return e.addSection(0, title, body)
+	return e.addSection(0, epubSection{title: title, parts: body})
 }
 func (e *Book) AddChapterMD(title, body string) error {
 	e.Lock()
@@ -283,7 +500,32 @@ func (e *Book) AddChapterMD(title, body string) error {
 	return e.AddChapterHTML(title, content)
 }
 func (e *Book) AddChapterHTML(title string, body []string) error {
-	return e.addSection(1, title, body)
+	return e.addSection(1, epubSection{title: title, parts: body})
+}
+
+// PartOptions holds the optional text of a part's divider page.
+type PartOptions struct {
+	// Label is shown above the title, such as "Part One"
+	Label string
+	// Subtitle is shown below the title
+	Subtitle string
+	// Content is HTML shown below the subtitle
+	Content string
+}
+
+// AddPart adds a divider page using the part.xhtml template. Every
+// chapter added after it, up to the next part, is nested under it
+// in the table of contents.
+func (e *Book) AddPart(title string, opts PartOptions) error {
+	s := epubSection{
+		title:       title,
+		parts:       []string{opts.Content},
+		template:    "part.xhtml",
+		sectionType: "part",
+		label:       opts.Label,
+		subtitle:    opts.Subtitle,
+	}
+	return e.addSection(1, s)
 }
 func (e *Book) AddPostscriptMD(title, body string) error {
 	e.Lock()
@@ -295,16 +537,12 @@ func (e *Book) AddPostscriptMD(title, body string) error {
 	return e.AddPostscriptHTML(title, content)
 }
 func (e *Book) AddPostscriptHTML(title string, body []string) error {
-	return e.addSection(2, title, body)
+	return e.addSection(2, epubSection{title: title, parts: body})
 }
 
-func (e *Book) addSection(priority int, title string, bodies []string) error {
+func (e *Book) addSection(priority int, s epubSection) error {
 	e.Lock()
 	defer e.Unlock()
-	s := epubSection{
-		title: title,
-		parts: bodies,
-	}
 	e.sections[priority] = append(e.sections[priority], s)
 
 	return nil
@@ -316,11 +554,27 @@ func (e *Book) Title() string {
 func (e *Book) SetTitle(t string) {
 	e.args.Title = t
 }
+
+// Author returns the names of the book's authors, separated by
+// commas.
 func (e *Book) Author() string {
 	return e.args.Author
 }
+
+// SetAuthor replaces the book's authors with a single author. Use
+// AddCreator to add more authors or other roles.
 func (e *Book) SetAuthor(author string) {
-	e.args.Author = author
+	creators := []bookCreator{}
+	if author != "" {
+		creators = append(creators, bookCreator{Name: author, Role: RoleAuthor})
+	}
+	for _, c := range e.args.Creators {
+		if c.Role != RoleAuthor {
+			creators = append(creators, c)
+		}
+	}
+	e.args.Creators = creators
+	e.updateCreators()
 }
 func (e *Book) Description() string {
 	return e.args.Description
@@ -340,9 +594,3 @@ func (e *Book) ReleaseDate() string {
 func (e *Book) SetReleaseDate(t string) {
 	e.args.ReleaseDate = t
 }
-func (e *Book) Identifier() string {
-	return e.args.URN
-}
-func (e *Book) SetIdentifier(id string) {
-	e.args.URN = id
-}
@@ -7,123 +7,70 @@ import (
 	"github.com/cahaba-ts/epub"
 )
 
-func ExampleEpub_AddCSS() {
+func ExampleBook_SetCSS() {
 	e := epub.NewBook("My title")
 
-	// Add CSS
-	css1Path, err := e.AddCSS("testdata/cover.css", "epub.css")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// The filename is optional
-	css2Path, err := e.AddCSS("testdata/cover.css", "")
-	if err != nil {
+	// Replace the built-in stylesheet
+	if err := e.SetCSS("testdata/cover.css"); err != nil {
 		log.Fatal(err)
 	}
-
-	// Use the CSS in a section
-	sectionBody := `    <h1>Section 1</h1>
-	<p>This is a paragraph.</p>`
-	e.AddSection(sectionBody, "Section 1", "", css1Path)
-
-	fmt.Println(css1Path)
-	fmt.Println(css2Path)
-
-	// Output:
-	// ../css/epub.css
-	// ../css/cover.css
 }
 
-func ExampleEpub_AddFont() {
-	e := epub.NewEpub("My title")
+func ExampleBook_AddAsset() {
+	e := epub.NewBook("My title")
 
 	// Add a font from a local file
-	font1Path, err := e.AddFont("testdata/redacted-script-regular.ttf", "font.ttf")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// The filename is optional
-	font2Path, err := e.AddFont("testdata/redacted-script-regular.ttf", "")
+	err := e.AddAsset("testdata/redacted-script-regular.ttf", "font.ttf", "font/ttf")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	fmt.Println(font1Path)
-	fmt.Println(font2Path)
+	_, ok := e.LookupAsset("font.ttf")
+	fmt.Println(ok)
 
 	// Output:
-	// ../fonts/font.ttf
-	// ../fonts/redacted-script-regular.ttf
+	// true
 }
 
-func ExampleEpub_AddImage() {
-	e := epub.NewEpub("My title")
+func ExampleBook_AddImage() {
+	e := epub.NewBook("My title")
 
 	// Add an image from a local file
-	img1Path, err := e.AddImage("testdata/gophercolor16x16.png", "go-gopher.png")
-	if err != nil {
+	if err := e.AddImage("testdata/gophercolor16x16.png", "go-gopher.png"); err != nil {
 		log.Fatal(err)
 	}
 
-	// Add an image from a URL. The filename is optional
-	img2Path, err := e.AddImage("https://golang.org/doc/gopher/gophercolor16x16.png", "")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	fmt.Println(img1Path)
-	fmt.Println(img2Path)
+	_, ok := e.LookupImage("go-gopher.png")
+	fmt.Println(ok)
 
 	// Output:
-	// ../images/go-gopher.png
-	// ../images/gophercolor16x16.png
+	// true
 }
 
-func ExampleEpub_AddSection() {
-	e := epub.NewEpub("My title")
+func ExampleBook_AddChapterMD() {
+	e := epub.NewBook("My title")
 
-	// Add a section. The CSS path is optional
-	section1Body := `    <h1>Section 1</h1>
-	<p>This is a paragraph.</p>`
-	section1Path, err := e.AddSection(section1Body, "Section 1", "firstsection.xhtml", "")
-	if err != nil {
+	if err := e.AddChapterMD("Section 1", "This is a paragraph."); err != nil {
 		log.Fatal(err)
 	}
 
-	// Link to the first section
-	section2Body := fmt.Sprintf(`    <h1>Section 2</h1>
-	<a href="%s">Link to section 1</a>`,
-		section1Path)
-	// The title and filename are also optional
-	section2Path, err := e.AddSection(section2Body, "", "", "")
-	if err != nil {
+	// Link to the first chapter by the slug of its title
+	if err := e.AddChapterMD("Section 2", "[Link to section 1](#chapter:section-1)"); err != nil {
 		log.Fatal(err)
 	}
-
-	fmt.Println(section1Path)
-	fmt.Println(section2Path)
-
-	// Output:
-	// firstsection.xhtml
-	// section0001.xhtml
 }
 
-func ExampleEpub_SetCover() {
-	e := epub.NewEpub("My title")
-
-	// Set the cover. The CSS file is optional
-	coverImagePath, _ := e.AddImage("testdata/gophercolor16x16.png", "cover.png")
-	e.SetCover(coverImagePath, "")
+func ExampleBook_SetCover() {
+	e := epub.NewBook("My title")
 
-	// Update the cover using custom CSS
-	coverCSSPath, _ := e.AddCSS("testdata/cover.css", "")
-	e.SetCover(coverImagePath, coverCSSPath)
+	// Set the cover. It must be a JPEG or PNG
+	if err := e.SetCover("testdata/gophercolor16x16.png"); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func ExampleEpub_SetIdentifier() {
-	e := epub.NewEpub("My title")
+func ExampleBook_SetIdentifier() {
+	e := epub.NewBook("My title")
 
 	// Set the identifier to a UUID
 	e.SetIdentifier("urn:uuid:a1b0d67e-2e81-4df5-9e67-a64cbe366809")
@@ -0,0 +1,301 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	refAttrRegex   = regexp.MustCompile(`(?:href|src|poster|xlink:href)\s*=\s*["']([^"']+)["']`)
+	cssURLRegex    = regexp.MustCompile(`url\(\s*['"]?([^'")]+)['"]?\s*\)`)
+	cssImportRegex = regexp.MustCompile(`@import\s+['"]([^'"]+)['"]`)
+)
+
+// uncompressed media types are already compressed, so deflating
+// them only costs time.
+var uncompressed = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/jxl":  true,
+	"image/heif": true,
+	"image/avif": true,
+	"font/woff":  true,
+	"font/woff2": true,
+}
+
+// finalizer turns a staged archive into the final epub. It drops
+// manifest items that nothing references, writes the zip entries
+// in a fixed order, and deflates everything but the mimetype and
+// already compressed media.
+type finalizer struct {
+	*epubArchive
+	modified time.Time
+}
+
+func newFinalizer(src *zip.Reader, modified time.Time) (*finalizer, error) {
+	a, err := openArchive(src)
+	if err != nil {
+		return nil, err
+	}
+	return &finalizer{
+		epubArchive: a,
+		modified:    zipTime(modified),
+	}, nil
+}
+
+// referenced walks the book from the spine, nav, ncx, and cover
+// and returns every manifest item that can be reached, including
+// the fallbacks and media overlays of the items it reaches.
+func (f *finalizer) referenced() (map[string]bool, error) {
+	byID := make(map[string]opfItem)
+	byPath := make(map[string]opfItem)
+	for _, item := range f.pkg.Manifest {
+		byID[item.ID] = item
+		byPath[f.itemPath(item)] = item
+	}
+
+	seen := make(map[string]bool)
+	queue := []opfItem{}
+	visit := func(item opfItem) {
+		if seen[item.ID] {
+			return
+		}
+		seen[item.ID] = true
+		queue = append(queue, item)
+	}
+
+	for _, ref := range f.pkg.Spine.Itemrefs {
+		if item, ok := byID[ref.IDRef]; ok {
+			visit(item)
+		}
+	}
+	if item, ok := byID[f.pkg.Spine.Toc]; ok {
+		visit(item)
+	}
+	for _, m := range f.pkg.Metadata.Metas {
+		if m.Name != "cover" {
+			continue
+		}
+		if item, ok := byID[m.Content]; ok {
+			visit(item)
+		}
+		if item, ok := byPath[m.Content]; ok {
+			visit(item)
+		}
+	}
+	for _, item := range f.pkg.Manifest {
+		for _, p := range strings.Fields(item.Properties) {
+			if p == "nav" || p == "cover-image" {
+				visit(item)
+			}
+		}
+	}
+
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+		for _, id := range []string{item.Fallback, item.MediaOverlay} {
+			if target, ok := byID[id]; ok {
+				visit(target)
+			}
+		}
+		if !hasReferences(item.MediaType) {
+			continue
+		}
+		name := f.itemPath(item)
+		b, err := f.read(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range findRefs(b) {
+			if target, ok := byPath[resolveRef(name, ref)]; ok {
+				visit(target)
+			}
+		}
+	}
+	return seen, nil
+}
+
+func hasReferences(mediaType string) bool {
+	switch mediaType {
+	case mtXHTML, mtNCX, "text/css", "image/svg+xml", "text/html", "application/smil+xml":
+		return true
+	}
+	return false
+}
+
+// findRefs returns the raw href, src, and url() references in b.
+func findRefs(b []byte) []string {
+	refs := []string{}
+	for _, re := range []*regexp.Regexp{refAttrRegex, cssURLRegex, cssImportRegex} {
+		for _, m := range re.FindAllSubmatch(b, -1) {
+			refs = append(refs, string(m[1]))
+		}
+	}
+	return refs
+}
+
+// resolveRef resolves ref relative to the archive path of the file
+// that contains it. External and fragment-only references resolve
+// to an empty string.
+func resolveRef(from, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if i := strings.IndexAny(ref, "#?"); i >= 0 {
+		ref = ref[:i]
+	}
+	if ref == "" || strings.Contains(ref, ":") {
+		return ""
+	}
+	if unescaped, err := url.PathUnescape(ref); err == nil {
+		ref = unescaped
+	}
+	if strings.HasPrefix(ref, "/") {
+		return strings.TrimPrefix(path.Clean(ref), "/")
+	}
+	return path.Join(path.Dir(from), ref)
+}
+
+// pruneManifest removes the <item> elements of content.opf that
+// are not in keep.
+func (f *finalizer) pruneManifest(keep map[string]bool) []byte {
+	out := f.opf
+	for _, item := range f.pkg.Manifest {
+		if keep[item.ID] {
+			continue
+		}
+		id := regexp.QuoteMeta(item.ID)
+		re := regexp.MustCompile(`[ \t]*<item\s[^>]*\bid\s*=\s*(?:"` + id + `"|'` + id + `')[^>]*(?:/>|>\s*</item>)[ \t]*\r?\n?`)
+		out = re.ReplaceAll(out, nil)
+	}
+	return out
+}
+
+// Write writes the finalized epub to w.
+func (f *finalizer) Write(w io.Writer) error {
+	keep, err := f.referenced()
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	// setting Modified adds an extra field, which isn't
+	// allowed on the mimetype entry
+	date, clock := msDosTime(f.modified)
+	mt, err := zw.CreateHeader(&zip.FileHeader{
+		Name:         "mimetype",
+		Method:       zip.Store,
+		ModifiedDate: date,
+		ModifiedTime: clock,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mt, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	meta := []string{}
+	for name := range f.files {
+		if strings.HasPrefix(name, "META-INF/") && !strings.HasSuffix(name, "/") {
+			meta = append(meta, name)
+		}
+	}
+	sort.Slice(meta, func(i, j int) bool {
+		// container.xml always comes first
+		if meta[i] == "META-INF/container.xml" || meta[j] == "META-INF/container.xml" {
+			return meta[i] == "META-INF/container.xml"
+		}
+		return meta[i] < meta[j]
+	})
+	for _, name := range meta {
+		if err := f.copy(zw, name, true); err != nil {
+			return err
+		}
+	}
+	if err := f.create(zw, f.opfPath, f.pruneManifest(keep), true); err != nil {
+		return err
+	}
+
+	// spine items are written in reading order, followed by
+	// the rest of the manifest in manifest order
+	written := make(map[string]bool)
+	items := []opfItem{}
+	byID := make(map[string]opfItem)
+	for _, item := range f.pkg.Manifest {
+		byID[item.ID] = item
+	}
+	for _, ref := range f.pkg.Spine.Itemrefs {
+		if item, ok := byID[ref.IDRef]; ok {
+			items = append(items, item)
+		}
+	}
+	items = append(items, f.pkg.Manifest...)
+	for _, item := range items {
+		name := f.itemPath(item)
+		if !keep[item.ID] || written[name] {
+			continue
+		}
+		written[name] = true
+		if err := f.copy(zw, name, !uncompressed[item.MediaType]); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func (f *finalizer) copy(zw *zip.Writer, name string, compress bool) error {
+	b, err := f.read(name)
+	if err != nil {
+		return err
+	}
+	return f.create(zw, name, b, compress)
+}
+
+func (f *finalizer) create(zw *zip.Writer, name string, b []byte, compress bool) error {
+	method := zip.Store
+	if compress {
+		method = zip.Deflate
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   method,
+		Modified: f.modified,
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Create %s", name))
+	}
+	_, err = io.Copy(w, bytes.NewReader(b))
+	return err
+}
+
+// zipTime clamps t to the times a zip header can hold, so a
+// SOURCE_DATE_EPOCH of 0 doesn't wrap around.
+func zipTime(t time.Time) time.Time {
+	if t.Before(zipEpoch) {
+		return zipEpoch
+	}
+	if last := time.Date(2107, 12, 31, 23, 59, 58, 0, time.UTC); t.After(last) {
+		return last
+	}
+	return t
+}
+
+// msDosTime converts t to the date and time fields of a zip header.
+func msDosTime(t time.Time) (uint16, uint16) {
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
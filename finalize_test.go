@@ -0,0 +1,153 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// finalizeTest finalizes the epub made of files and returns it.
+func finalizeTest(t *testing.T, files map[string]string) *zip.Reader {
+	t.Helper()
+	b := zipBook(t, files)
+	staged, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := newFinalizer(staged, time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	must(t, f.Write(buf))
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestFinalizePrune(t *testing.T) {
+	files := testBook()
+	files["OEBPS/content.opf"] = strings.Replace(testOPF, `  </manifest>`, `    <item id="ch01-overlay" href="ch01.smil" media-type="application/smil+xml"/>
+    <item id="narration" href="ch01.mp3" media-type="audio/mpeg"/>
+    <item id="photo" href="photo.webp" media-type="image/webp" fallback="photo-jpeg"/>
+    <item id="photo-jpeg" href="photo.jpg" media-type="image/jpeg"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+    <item id="font" href="font.ttf" media-type="font/ttf"/>
+    <item id='unused' href='unused.png' media-type='image/png'/>
+    <item id="unused2" href="unused2.png" media-type="image/png"></item>
+  </manifest>`, 1)
+	files["OEBPS/content.opf"] = strings.Replace(files["OEBPS/content.opf"],
+		`<item id="ch01" href="ch01.xhtml" media-type="application/xhtml+xml"/>`,
+		`<item id="ch01" href="ch01.xhtml" media-type="application/xhtml+xml" media-overlay="ch01-overlay"/>`, 1)
+	files["OEBPS/ch01.xhtml"] = strings.Replace(files["OEBPS/ch01.xhtml"], "<head>",
+		`<head><link href="style.css" rel="stylesheet" type="text/css"/>`, 1)
+	files["OEBPS/ch02.xhtml"] = strings.Replace(files["OEBPS/ch02.xhtml"], "The note.", `<img src="photo.webp" alt=""/>`, 1)
+	files["OEBPS/ch01.smil"] = `<smil xmlns="http://www.w3.org/ns/SMIL" version="3.0"><body><par>
+<text src="ch01.xhtml#p1"/><audio src="ch01.mp3"/>
+</par></body></smil>`
+	files["OEBPS/style.css"] = `@font-face { src: url('font.ttf'); }`
+	for _, name := range []string{"ch01.mp3", "photo.webp", "photo.jpg", "font.ttf", "unused.png", "unused2.png"} {
+		files["OEBPS/"+name] = name
+	}
+
+	zr := finalizeTest(t, files)
+	written := make(map[string]bool)
+	for _, zf := range zr.File {
+		written[zf.Name] = true
+	}
+	for _, name := range []string{"ch01.smil", "ch01.mp3", "photo.webp", "photo.jpg", "style.css", "font.ttf"} {
+		if !written["OEBPS/"+name] {
+			t.Errorf("Referenced file %s was dropped", name)
+		}
+	}
+	for _, name := range []string{"unused.png", "unused2.png"} {
+		if written["OEBPS/"+name] {
+			t.Errorf("Unreferenced file %s was kept", name)
+		}
+	}
+	opf := readZipFile(t, zr, "OEBPS/content.opf")
+	if strings.Contains(opf, "unused") {
+		t.Errorf("Unreferenced items are still in the manifest:\n%s", opf)
+	}
+	if !strings.Contains(opf, `fallback="photo-jpeg"`) {
+		t.Error("Fallback item was removed from the manifest")
+	}
+}
+
+func TestFinalizeOrder(t *testing.T) {
+	files := testBook()
+	files["META-INF/com.apple.ibooks.display-options.xml"] = "<display_options/>"
+	zr := finalizeTest(t, files)
+
+	want := []string{
+		"mimetype",
+		"META-INF/container.xml",
+		"META-INF/com.apple.ibooks.display-options.xml",
+		"OEBPS/content.opf",
+		"OEBPS/ch01.xhtml",
+		"OEBPS/ch02.xhtml",
+		"OEBPS/nav.xhtml",
+	}
+	got := []string{}
+	for _, zf := range zr.File {
+		got = append(got, zf.Name)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got entries %v, want %v", got, want)
+	}
+
+	mt := zr.File[0]
+	if mt.Method != zip.Store || len(mt.Extra) != 0 {
+		t.Errorf("mimetype is method %d with %d bytes of extra fields", mt.Method, len(mt.Extra))
+	}
+	for _, zf := range zr.File[1:] {
+		if zf.Method != zip.Deflate {
+			t.Errorf("%s isn't compressed", zf.Name)
+		}
+		if !zf.Modified.Equal(time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)) {
+			t.Errorf("%s was modified %v", zf.Name, zf.Modified)
+		}
+	}
+}
+
+func TestZipTime(t *testing.T) {
+	tests := []struct {
+		in, want time.Time
+	}{
+		{time.Unix(0, 0).UTC(), zipEpoch},
+		{zipEpoch, zipEpoch},
+		{time.Date(2020, 5, 6, 7, 8, 10, 0, time.UTC), time.Date(2020, 5, 6, 7, 8, 10, 0, time.UTC)},
+		{time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2107, 12, 31, 23, 59, 58, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := zipTime(tt.in); !got.Equal(tt.want) {
+			t.Errorf("zipTime(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+// readZipFile returns the contents of the named file in zr.
+func readZipFile(t *testing.T, zr *zip.Reader, name string) string {
+	t.Helper()
+	for _, zf := range zr.File {
+		if zf.Name != name {
+			continue
+		}
+		r, err := zf.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		buf := &bytes.Buffer{}
+		if _, err := buf.ReadFrom(r); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	t.Fatalf("%s isn't in the epub", name)
+	return ""
+}
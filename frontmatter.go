@@ -0,0 +1,113 @@
+package epub
+
+import (
+	"strings"
+)
+
+// CopyrightOptions holds the parts of the copyright page that
+// aren't book metadata.
+type CopyrightOptions struct {
+	// Edition is shown below the copyright line, such as
+	// "First Edition"
+	Edition string
+	// Notice is markdown shown at the end of the page, such as a
+	// work of fiction disclaimer or cover credits
+	Notice string
+}
+
+// AddTitlePage adds a title page using the titlepage.xhtml
+// template. The title, authors, series, and publisher are filled
+// in from the book when it is built.
+func (e *Book) AddTitlePage() error {
+	return e.addSection(0, epubSection{
+		title:       "Title Page",
+		parts:       []string{""},
+		template:    "titlepage.xhtml",
+		sectionType: "titlepage",
+	})
+}
+
+// AddCopyrightPage adds a copyright page using the copyright.xhtml
+// template. The rights statement, ISBN, publisher, and release
+// date are filled in from the book when it is built; without a
+// rights statement a copyright line is made from the release year
+// and author.
+func (e *Book) AddCopyrightPage(opts CopyrightOptions) error {
+	notice, err := e.renderMarkdownPage(opts.Notice)
+	if err != nil {
+		return err
+	}
+	return e.addSection(0, epubSection{
+		title:       "Copyright",
+		parts:       []string{notice},
+		template:    "copyright.xhtml",
+		sectionType: "copyright-page",
+		label:       opts.Edition,
+	})
+}
+
+// AddDedication adds a dedication page using the dedication.xhtml
+// template. text is markdown.
+func (e *Book) AddDedication(text string) error {
+	content, err := e.renderMarkdownPage(text)
+	if err != nil {
+		return err
+	}
+	return e.addSection(0, epubSection{
+		title:       "Dedication",
+		parts:       []string{content},
+		template:    "dedication.xhtml",
+		sectionType: "dedication",
+	})
+}
+
+// AddEpigraph adds an epigraph page using the epigraph.xhtml
+// template. quote is markdown, and attribution is plain text shown
+// below it.
+func (e *Book) AddEpigraph(quote, attribution string) error {
+	content, err := e.renderMarkdownPage(quote)
+	if err != nil {
+		return err
+	}
+	return e.addSection(0, epubSection{
+		title:       "Epigraph",
+		parts:       []string{content},
+		template:    "epigraph.xhtml",
+		sectionType: "epigraph",
+		subtitle:    attribution,
+	})
+}
+
+// renderMarkdownPage renders markdown that must fit on one page.
+func (e *Book) renderMarkdownPage(body string) (string, error) {
+	e.Lock()
+	parts, err := e.renderMarkdown(body)
+	e.Unlock()
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+// isbn returns the ISBN of the book, from either the unique
+// identifier or an alternate identifier.
+func (e *Book) isbn() string {
+	if strings.HasPrefix(e.args.URN, "urn:isbn:") {
+		return strings.TrimPrefix(e.args.URN, "urn:isbn:")
+	}
+	for _, id := range e.args.Identifiers {
+		if strings.HasPrefix(id.Value, "urn:isbn:") {
+			return strings.TrimPrefix(id.Value, "urn:isbn:")
+		}
+	}
+	return ""
+}
+
+// releaseYear returns the year of the release date, or of the
+// modified time when there is no release date.
+func (e *Book) releaseYear() string {
+	if d := e.args.ReleaseDate; len(d) >= 4 && strings.Trim(d[:4], "0123456789") == "" {
+		return d[:4]
+	}
+	return e.modified.Format("2006")
+}
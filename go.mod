@@ -6,5 +6,8 @@ require (
 	github.com/gofrs/uuid v3.1.0+incompatible
 	github.com/pkg/errors v0.9.1
 	github.com/yuin/goldmark v1.4.12
+	golang.org/x/image v0.18.0
 	golang.org/x/net v0.0.0-20210505024714-0287a6fb4125
 )
+
+require golang.org/x/text v0.16.0 // indirect
@@ -0,0 +1,153 @@
+package epub
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+)
+
+// identifierNamespace is the UUIDv5 namespace for identifiers
+// generated from the title and author.
+var identifierNamespace = uuid.Must(uuid.FromString("f56aa406-cb68-4abf-919c-7cc029c892ef"))
+
+// identifierTypes maps identifier schemes to their ONIX code list 5
+// identifier type, and whether the value is written as a URN.
+var identifierTypes = map[string]struct {
+	onix string
+	urn  bool
+}{
+	"isbn": {"15", true},
+	"issn": {"", true},
+	"doi":  {"06", true},
+	"uuid": {"", true},
+	"asin": {"01", false},
+	"gtin": {"03", false},
+}
+
+// onixISBN10 is the ONIX identifier type of ISBN-10s, which the
+// isbn scheme's type, 15, doesn't cover.
+const onixISBN10 = "02"
+
+type bookIdentifier struct {
+	ID         string
+	Value      string
+	Type       string
+	TypeScheme string
+}
+
+// Identifier returns the unique identifier of the book. If none was
+// set, it is a UUIDv5 URN generated from the title and author, so
+// that rebuilding a book keeps the same identifier.
+func (e *Book) Identifier() string {
+	if e.args.URN != "" {
+		return e.args.URN
+	}
+	name := e.args.Title + "\n" + e.args.Author
+	return "urn:uuid:" + uuid.NewV5(identifierNamespace, name).String()
+}
+
+// SetIdentifier sets the unique identifier of the book. It should
+// include its scheme, like "urn:isbn:9780101010101"; a bare UUID is
+// written as "urn:uuid:<uuid>".
+func (e *Book) SetIdentifier(id string) {
+	if _, err := uuid.FromString(id); err == nil && !strings.HasPrefix(id, "urn:") {
+		id = "urn:uuid:" + id
+	}
+	e.args.URN = id
+}
+
+// SetISBN validates the check digit of an ISBN-10 or ISBN-13 and
+// makes it the unique identifier of the book. Hyphens and spaces
+// are removed.
+func (e *Book) SetISBN(isbn string) error {
+	isbn, err := normalizeISBN(isbn)
+	if err != nil {
+		return err
+	}
+	e.args.URN = "urn:isbn:" + isbn
+	return nil
+}
+
+// AddAlternateIdentifier adds an identifier other than the unique
+// one, such as the ASIN of the Kindle edition or a DOI. Known
+// schemes (isbn, issn, doi, uuid, asin, gtin) are written in their
+// standard form with an ONIX identifier type; any other scheme is
+// written as the identifier type as is.
+func (e *Book) AddAlternateIdentifier(scheme, value string) error {
+	scheme = strings.ToLower(strings.TrimSpace(scheme))
+	value = strings.TrimSpace(value)
+	if scheme == "" || value == "" {
+		return errors.New("Alternate identifier needs a scheme and a value")
+	}
+	if scheme == "isbn" {
+		isbn, err := normalizeISBN(value)
+		if err != nil {
+			return err
+		}
+		value = isbn
+	}
+
+	id := bookIdentifier{
+		ID:    fmt.Sprintf("alt-id%02d", len(e.args.Identifiers)+1),
+		Value: value,
+		Type:  scheme,
+	}
+	if t, ok := identifierTypes[scheme]; ok {
+		if t.urn {
+			id.Value = "urn:" + scheme + ":" + strings.TrimPrefix(value, "urn:"+scheme+":")
+		}
+		id.Type = t.onix
+		if scheme == "isbn" && len(value) == 10 {
+			id.Type = onixISBN10
+		}
+		if t.onix != "" {
+			id.TypeScheme = "onix:codelist5"
+		}
+	}
+	e.args.Identifiers = append(e.args.Identifiers, id)
+	return nil
+}
+
+// normalizeISBN strips hyphens and spaces from an ISBN and checks
+// its length and check digit.
+func normalizeISBN(isbn string) (string, error) {
+	isbn = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(isbn)), "urn:isbn:")
+	isbn = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(isbn))
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, c := range isbn {
+			d := int(c - '0')
+			switch {
+			case c == 'X' && i == 9:
+				d = 10
+			case c < '0' || c > '9':
+				return "", errors.Errorf("Invalid ISBN-10: %s", isbn)
+			}
+			sum += (10 - i) * d
+		}
+		if sum%11 != 0 {
+			return "", errors.Errorf("Invalid ISBN-10 check digit: %s", isbn)
+		}
+	case 13:
+		sum := 0
+		for i, c := range isbn {
+			if c < '0' || c > '9' {
+				return "", errors.Errorf("Invalid ISBN-13: %s", isbn)
+			}
+			d := int(c - '0')
+			if i%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+		if sum%10 != 0 {
+			return "", errors.Errorf("Invalid ISBN-13 check digit: %s", isbn)
+		}
+	default:
+		return "", errors.Errorf("ISBN must have 10 or 13 digits: %s", isbn)
+	}
+	return isbn, nil
+}
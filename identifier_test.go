@@ -0,0 +1,61 @@
+package epub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		in, want string
+		valid    bool
+	}{
+		{"978-0-306-40615-7", "9780306406157", true},
+		{"urn:isbn:9780306406157", "9780306406157", true},
+		{"0 306 40615 2", "0306406152", true},
+		{"0-8044-2957-x", "080442957X", true},
+		{"978-0-306-40615-8", "", false},
+		{"0-306-40615-3", "", false},
+		{"03064061X2", "", false},
+		{"978030640615A", "", false},
+		{"978030640615", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, err := normalizeISBN(tt.in)
+		if (err == nil) != tt.valid || got != tt.want {
+			t.Errorf("normalizeISBN(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestIdentifiers(t *testing.T) {
+	e := NewBook("Test Book")
+	if err := e.SetISBN("978-0-306-40615-8"); err == nil {
+		t.Error("SetISBN accepted a wrong check digit")
+	}
+	if !strings.HasPrefix(e.Identifier(), "urn:uuid:") {
+		t.Errorf("Identifier is %q after a rejected ISBN", e.Identifier())
+	}
+	must(t, e.SetISBN("978-0-306-40615-7"))
+	must(t, e.AddAlternateIdentifier("ISBN", "0-306-40615-2"))
+	must(t, e.AddAlternateIdentifier("asin", "B000FA5ZEG"))
+	if err := e.AddAlternateIdentifier("isbn", "0306406153"); err == nil {
+		t.Error("AddAlternateIdentifier accepted a wrong ISBN-10 check digit")
+	}
+	if e.Identifier() != "urn:isbn:9780306406157" {
+		t.Errorf("Identifier is %q", e.Identifier())
+	}
+	must(t, e.AddChapterMD("One", "Text."))
+	opf := documents(t, buildValid(t, e))["OEBPS/content.opf"]
+	for _, want := range []string{
+		">urn:isbn:9780306406157</dc:identifier>",
+		">urn:isbn:0306406152</dc:identifier>",
+		`property="identifier-type" scheme="onix:codelist5">02</meta>`,
+		">B000FA5ZEG</dc:identifier>",
+	} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("content.opf is missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	xdraw "golang.org/x/image/draw"
+
+	// decoders for the formats that can be converted
+	_ "image/gif"
+
+	_ "golang.org/x/image/webp"
+)
+
+// CompatibilityProfile is the set of image formats the readers a
+// book is made for can show. Images in other formats are converted
+// to JPEG, or to PNG when they are transparent.
+type CompatibilityProfile string
+
+const (
+	// ProfileAny keeps every image in the format it was added in.
+	ProfileAny CompatibilityProfile = ""
+	// ProfileEPUB3 allows the EPUB 3.3 core media types: JPEG,
+	// PNG, GIF, SVG, and WebP.
+	ProfileEPUB3 CompatibilityProfile = "epub3"
+	// ProfileCompatible allows only JPEG, PNG, GIF, and SVG, which
+	// every reader, including older Kindles and Kobos, can show.
+	ProfileCompatible CompatibilityProfile = "compatible"
+)
+
+// profileTypes are the media types each profile allows.
+var profileTypes = map[CompatibilityProfile]map[string]bool{
+	ProfileEPUB3: {
+		"image/jpeg":    true,
+		"image/png":     true,
+		"image/gif":     true,
+		"image/svg+xml": true,
+		"image/webp":    true,
+	},
+	ProfileCompatible: {
+		"image/jpeg":    true,
+		"image/png":     true,
+		"image/gif":     true,
+		"image/svg+xml": true,
+	},
+}
+
+// needsDecoder are the media types Go has no decoder for unless
+// the caller registers one.
+var needsDecoder = map[string]bool{
+	"image/avif": true,
+	"image/heif": true,
+	"image/jxl":  true,
+}
+
+// ImageOptions controls how images are processed as they are added
+// with AddImage, AddImageFolder, SetCover, or from markdown. The
+// zero value stores images exactly as they are.
+//
+// Go can only decode AVIF, HEIF, and JPEG XL images when a decoder
+// for them has been registered with the image package, by importing
+// one for its side effects. Without one, adding them fails when
+// they need converting. JPEGs that are processed are turned upright
+// for their EXIF orientation, since encoding them drops it.
+type ImageOptions struct {
+	// MaxDimension scales images down so that neither side is
+	// longer, in pixels. 0 means no limit.
+	MaxDimension int
+	// JPEGQuality re-encodes JPEGs at this quality, from 1 to 100.
+	// 0 only re-encodes JPEGs that are resized or converted, at
+	// quality 85.
+	JPEGQuality int
+	// OptimizePNG re-encodes PNGs with the best compression.
+	OptimizePNG bool
+	// Profile converts images in formats it doesn't allow.
+	Profile CompatibilityProfile
+}
+
+// SetImageOptions sets how images added after it are processed.
+func (e *Book) SetImageOptions(opts ImageOptions) error {
+	if _, ok := profileTypes[opts.Profile]; !ok && opts.Profile != ProfileAny {
+		return errors.Errorf("Invalid compatibility profile: %q", opts.Profile)
+	}
+	if opts.JPEGQuality < 0 || opts.JPEGQuality > 100 {
+		return errors.Errorf("JPEG quality must be from 1 to 100, not %d", opts.JPEGQuality)
+	}
+	e.Lock()
+	e.imageOpts = opts
+	e.Unlock()
+	return nil
+}
+
+// processImage resizes, re-encodes, or converts an image as the
+// image options ask. It returns the image with its new filename
+// and media type, which only change when the format does.
+func (e *Book) processImage(r io.Reader, filename, mediaType string) (io.Reader, string, string, error) {
+	opts := e.imageOpts
+	if opts == (ImageOptions{}) || mediaType == "image/svg+xml" || mediaType == "image/gif" {
+		// gifs may be animated, and svgs scale on their own
+		return r, filename, mediaType, nil
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", "", err
+	}
+	cfg, _, cfgErr := image.DecodeConfig(bytes.NewReader(b))
+
+	allowed := profileTypes[opts.Profile]
+	convert := allowed != nil && !allowed[mediaType] && strings.HasPrefix(mediaType, "image/")
+	resize := cfgErr == nil && opts.MaxDimension > 0 &&
+		(cfg.Width > opts.MaxDimension || cfg.Height > opts.MaxDimension)
+	reencode := (mediaType == "image/jpeg" && opts.JPEGQuality > 0) ||
+		(mediaType == "image/png" && opts.OptimizePNG)
+	if !convert && !resize && !reencode {
+		return bytes.NewReader(b), filename, mediaType, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err == image.ErrFormat && needsDecoder[mediaType] {
+		return nil, "", "", errors.Errorf(
+			"Process image %s: no %s decoder is registered, import one for its side effects to convert it",
+			filename, mediaType,
+		)
+	}
+	if err != nil {
+		return nil, "", "", errors.Wrapf(err, "Process image %s (%s)", filename, mediaType)
+	}
+	if mediaType == "image/jpeg" {
+		// the orientation is lost when the image is encoded again
+		img = orient(img, jpegOrientation(b))
+	}
+	if resize {
+		img = scaleImage(img, opts.MaxDimension)
+	}
+
+	// formats Go can't encode become JPEG, or PNG to keep
+	// transparency
+	target := mediaType
+	if target != "image/jpeg" && target != "image/png" {
+		target = "image/jpeg"
+		if !isOpaque(img) {
+			target = "image/png"
+		}
+	}
+
+	out := &bytes.Buffer{}
+	switch target {
+	case "image/jpeg":
+		quality := opts.JPEGQuality
+		if quality == 0 {
+			quality = 85
+		}
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: quality})
+	case "image/png":
+		enc := &png.Encoder{}
+		if opts.OptimizePNG {
+			enc.CompressionLevel = png.BestCompression
+		}
+		err = enc.Encode(out, img)
+	}
+	if err != nil {
+		return nil, "", "", errors.Wrapf(err, "Encode image %s", filename)
+	}
+	if target != mediaType {
+		ext := ".jpg"
+		if target == "image/png" {
+			ext = ".png"
+		}
+		filename = strings.TrimSuffix(filename, path.Ext(filename)) + ext
+	}
+	return out, filename, target, nil
+}
+
+// scaleImage scales img down to fit in a max by max square.
+func scaleImage(img image.Image, max int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w >= h {
+		h = h * max / w
+		w = max
+	} else {
+		w = w * max / h
+		h = max
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG, from 1 to
+// 8, or 1 when it has none.
+func jpegOrientation(b []byte) int {
+	if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(b) && b[i] == 0xFF; {
+		marker := b[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			// the image data starts without any exif
+			break
+		}
+		size := int(b[i+2])<<8 | int(b[i+3])
+		if size < 2 || i+2+size > len(b) {
+			break
+		}
+		segment := b[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of
+// the TIFF structure in an exif segment.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+		}
+	}
+	return 1
+}
+
+// orient flips and rotates img so it displays upright for its EXIF
+// orientation.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if orientation >= 5 {
+		// 5 through 8 turn the image on its side
+		dst = image.NewNRGBA(image.Rect(0, 0, h, w))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,217 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// exifJPEG returns a JPEG of a w by h image with an EXIF segment
+// holding orientation, in the given byte order.
+func exifJPEG(t *testing.T, w, h, orientation int, order binary.ByteOrder) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	must(t, jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, w, h)), nil))
+	b := buf.Bytes()
+
+	tiff := &bytes.Buffer{}
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(tiff, order, uint16(42))
+	binary.Write(tiff, order, uint32(8))
+	binary.Write(tiff, order, uint16(2))
+	// an unrelated tag before the orientation
+	binary.Write(tiff, order, []uint16{0x010F, 2, 0, 0, 0, 0})
+	binary.Write(tiff, order, []uint16{0x0112, 3})
+	binary.Write(tiff, order, uint32(1))
+	binary.Write(tiff, order, []uint16{uint16(orientation), 0})
+	binary.Write(tiff, order, uint32(0))
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	app1 := []byte{0xFF, 0xE1, byte((len(segment) + 2) >> 8), byte(len(segment) + 2)}
+	out := append([]byte{}, b[:2]...)
+	out = append(out, app1...)
+	out = append(out, segment...)
+	return append(out, b[2:]...)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	plain := &bytes.Buffer{}
+	must(t, jpeg.Encode(plain, image.NewGray(image.Rect(0, 0, 2, 2)), nil))
+	tests := []struct {
+		name string
+		b    []byte
+		want int
+	}{
+		{"little endian", exifJPEG(t, 2, 2, 6, binary.LittleEndian), 6},
+		{"big endian", exifJPEG(t, 2, 2, 8, binary.BigEndian), 8},
+		{"out of range", exifJPEG(t, 2, 2, 9, binary.BigEndian), 1},
+		{"no exif", plain.Bytes(), 1},
+		{"truncated", exifJPEG(t, 2, 2, 6, binary.LittleEndian)[:20], 1},
+		{"not a jpeg", testPNGBytes(t), 1},
+		{"empty", nil, 1},
+	}
+	for _, tt := range tests {
+		if got := jpegOrientation(tt.b); got != tt.want {
+			t.Errorf("%s: jpegOrientation = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// a 3 by 2 image with a red top left and a green pixel next
+	// to it
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	red, green := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 255, 0, 255}
+	src.Set(0, 0, red)
+	src.Set(1, 0, green)
+
+	tests := []struct {
+		orientation    int
+		w, h           int
+		redAt, greenAt image.Point
+	}{
+		{1, 3, 2, image.Pt(0, 0), image.Pt(1, 0)},
+		{2, 3, 2, image.Pt(2, 0), image.Pt(1, 0)},
+		{3, 3, 2, image.Pt(2, 1), image.Pt(1, 1)},
+		{4, 3, 2, image.Pt(0, 1), image.Pt(1, 1)},
+		{5, 2, 3, image.Pt(0, 0), image.Pt(0, 1)},
+		{6, 2, 3, image.Pt(1, 0), image.Pt(1, 1)},
+		{7, 2, 3, image.Pt(1, 2), image.Pt(1, 1)},
+		{8, 2, 3, image.Pt(0, 2), image.Pt(0, 1)},
+	}
+	for _, tt := range tests {
+		img := orient(src, tt.orientation)
+		if b := img.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("orient %d: got %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		if c := color.NRGBAModel.Convert(img.At(tt.redAt.X, tt.redAt.Y)); c != red {
+			t.Errorf("orient %d: red isn't at %v", tt.orientation, tt.redAt)
+		}
+		if c := color.NRGBAModel.Convert(img.At(tt.greenAt.X, tt.greenAt.Y)); c != green {
+			t.Errorf("orient %d: green isn't at %v", tt.orientation, tt.greenAt)
+		}
+	}
+}
+
+// testPNGBytes returns the test gopher, a transparent 16 by 15 png.
+func testPNGBytes(t *testing.T) []byte {
+	t.Helper()
+	b, err := os.ReadFile("testdata/gophercolor16x16.png")
+	must(t, err)
+	return b
+}
+
+func TestProcessImage(t *testing.T) {
+	opaque := &bytes.Buffer{}
+	must(t, png.Encode(opaque, image.NewGray(image.Rect(0, 0, 4, 2))))
+	tests := []struct {
+		name         string
+		opts         ImageOptions
+		b            []byte
+		filename     string
+		mediaType    string
+		wantFilename string
+		wantType     string
+		// wantSize is the size of the processed image, or zero
+		// when it's kept as it was
+		wantSize image.Point
+	}{
+		{
+			name: "no options", b: testPNGBytes(t), filename: "g.png", mediaType: "image/png",
+			wantFilename: "g.png", wantType: "image/png",
+		},
+		{
+			name: "allowed by profile", opts: ImageOptions{Profile: ProfileCompatible},
+			b: testPNGBytes(t), filename: "g.png", mediaType: "image/png",
+			wantFilename: "g.png", wantType: "image/png",
+		},
+		{
+			name: "resized", opts: ImageOptions{MaxDimension: 8},
+			b: testPNGBytes(t), filename: "g.png", mediaType: "image/png",
+			wantFilename: "g.png", wantType: "image/png", wantSize: image.Pt(8, 7),
+		},
+		{
+			name: "small enough", opts: ImageOptions{MaxDimension: 16},
+			b: testPNGBytes(t), filename: "g.png", mediaType: "image/png",
+			wantFilename: "g.png", wantType: "image/png",
+		},
+		{
+			name: "transparent converted", opts: ImageOptions{Profile: ProfileCompatible},
+			b: testPNGBytes(t), filename: "g.webp", mediaType: "image/webp",
+			wantFilename: "g.png", wantType: "image/png", wantSize: image.Pt(16, 15),
+		},
+		{
+			name: "opaque converted", opts: ImageOptions{Profile: ProfileCompatible},
+			b: opaque.Bytes(), filename: "o.webp", mediaType: "image/webp",
+			wantFilename: "o.jpg", wantType: "image/jpeg", wantSize: image.Pt(4, 2),
+		},
+		{
+			name: "rotated", opts: ImageOptions{JPEGQuality: 90},
+			b: exifJPEG(t, 4, 2, 6, binary.BigEndian), filename: "r.jpg", mediaType: "image/jpeg",
+			wantFilename: "r.jpg", wantType: "image/jpeg", wantSize: image.Pt(2, 4),
+		},
+		{
+			name: "gif kept", opts: ImageOptions{MaxDimension: 1, Profile: ProfileCompatible},
+			b: []byte("GIF89a"), filename: "a.gif", mediaType: "image/gif",
+			wantFilename: "a.gif", wantType: "image/gif",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewBook("Test Book")
+			must(t, e.SetImageOptions(tt.opts))
+			r, filename, mediaType, err := e.processImage(bytes.NewReader(tt.b), tt.filename, tt.mediaType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filename != tt.wantFilename || mediaType != tt.wantType {
+				t.Errorf("Got %s (%s), want %s (%s)", filename, mediaType, tt.wantFilename, tt.wantType)
+			}
+			b, err := io.ReadAll(r)
+			must(t, err)
+			if tt.wantSize == (image.Point{}) {
+				if !bytes.Equal(b, tt.b) {
+					t.Error("Image was changed")
+				}
+				return
+			}
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if "image/"+format != tt.wantType || cfg.Width != tt.wantSize.X || cfg.Height != tt.wantSize.Y {
+				t.Errorf("Got a %dx%d %s, want %v %s", cfg.Width, cfg.Height, format, tt.wantSize, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestProcessImageErrors(t *testing.T) {
+	e := NewBook("Test Book")
+	for _, opts := range []ImageOptions{{Profile: "kindle"}, {JPEGQuality: 101}} {
+		if err := e.SetImageOptions(opts); err == nil {
+			t.Errorf("SetImageOptions accepted %+v", opts)
+		}
+	}
+	must(t, e.SetImageOptions(ImageOptions{Profile: ProfileCompatible}))
+	_, _, _, err := e.processImage(strings.NewReader("not an image"), "a.avif", "image/avif")
+	if err == nil || !strings.Contains(err.Error(), "no image/avif decoder is registered") {
+		t.Errorf("Got %v, want the missing decoder", err)
+	}
+	_, _, _, err = e.processImage(strings.NewReader("not an image"), "a.webp", "image/webp")
+	if err == nil {
+		t.Error("Processed an image that doesn't decode")
+	}
+}
@@ -0,0 +1,230 @@
+package epub
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/cahaba-ts/epub/ruby"
+	"github.com/pkg/errors"
+	"github.com/yuin/goldmark"
+)
+
+// PageProgression is the direction pages are turned in.
+type PageProgression string
+
+const (
+	// LeftToRight is used by books in languages such as English.
+	LeftToRight PageProgression = "ltr"
+	// RightToLeft is used by books in languages such as Arabic, and
+	// by Japanese manga and vertical novels.
+	RightToLeft PageProgression = "rtl"
+	// DefaultProgression lets the reading system decide.
+	DefaultProgression PageProgression = "default"
+)
+
+// languageTagRegex matches the form of BCP 47 tags, including
+// private use ones like x-klingon.
+var languageTagRegex = regexp.MustCompile(`^([A-Za-z]{2,8}|[xXiI])(-[A-Za-z0-9]{1,8})*$`)
+
+// Language returns the BCP 47 language tag of the book.
+func (e *Book) Language() string {
+	return e.args.Language
+}
+
+// SetLanguage sets the BCP 47 language tag of the book, such as
+// "en", "ja", or "ar-EG". It is used for dc:language and for the
+// lang attributes of every generated file.
+func (e *Book) SetLanguage(tag string) error {
+	if !languageTagRegex.MatchString(tag) {
+		return errors.Errorf("Invalid language tag: %q", tag)
+	}
+	e.args.Language = tag
+	return nil
+}
+
+// PageProgression returns the page progression direction.
+func (e *Book) PageProgression() PageProgression {
+	return PageProgression(e.args.PageProgression)
+}
+
+// SetPageProgression sets the page-progression-direction of the
+// spine. Right to left books also get dir="rtl" on their chapters.
+func (e *Book) SetPageProgression(direction PageProgression) error {
+	switch direction {
+	case LeftToRight, RightToLeft, DefaultProgression:
+	default:
+		return errors.Errorf("Invalid page progression: %q", direction)
+	}
+	e.args.PageProgression = string(direction)
+	return nil
+}
+
+// direction returns the dir attribute for text in the book.
+// Vertical books turn pages right to left, but their text isn't
+// right to left.
+func (e *Book) direction() string {
+	if e.args.PageProgression == string(RightToLeft) && e.args.WritingMode == "" {
+		return "rtl"
+	}
+	return ""
+}
+
+// WritingMode is the direction lines of text are laid out in.
+type WritingMode string
+
+const (
+	// Horizontal books are laid out left to right, top to bottom.
+	Horizontal WritingMode = "horizontal-tb"
+	// Vertical books are laid out top to bottom, right to left, as
+	// used by Japanese novels.
+	Vertical WritingMode = "vertical-rl"
+)
+
+// WritingMode returns the writing mode of the book.
+func (e *Book) WritingMode() WritingMode {
+	if e.args.WritingMode == "" {
+		return Horizontal
+	}
+	return WritingMode(e.args.WritingMode)
+}
+
+// SetWritingMode switches the book between horizontal and vertical
+// text. Vertical books get vertical.css after the book's stylesheet,
+// the primary-writing-mode meta, a right to left spine, furigana
+// through the ruby markdown extension, and tate-chu-yoko for short
+// numbers.
+func (e *Book) SetWritingMode(mode WritingMode) error {
+	switch mode {
+	case Horizontal, Vertical:
+	default:
+		return errors.Errorf("Invalid writing mode: %q", mode)
+	}
+	if mode == e.WritingMode() {
+		return nil
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	if mode == Horizontal {
+		e.args.WritingMode = ""
+		e.args.VerticalStylesheet = ""
+		e.args.PageProgression = e.horizontalProgression
+		exts := []goldmark.Extender{}
+		for _, ext := range e.exts {
+			if ext != ruby.Extension {
+				exts = append(exts, ext)
+			}
+		}
+		e.exts = exts
+	} else {
+		e.horizontalProgression = e.args.PageProgression
+		e.args.WritingMode = string(mode)
+		e.args.VerticalStylesheet = "../vertical.css"
+		e.args.PageProgression = string(RightToLeft)
+		e.exts = append(e.exts, ruby.Extension)
+	}
+	// rebuilt with the new extensions on the next render
+	e.md = nil
+	return nil
+}
+
+// tateChuYoko sets runs of two or three digits, and doubled
+// punctuation like "!?", upright in vertical text.
+func tateChuYoko(content string) string {
+	out := &strings.Builder{}
+	skip := 0
+	// spans counts the spans open inside an upright span
+	spans := 0
+	last := 0
+	for _, loc := range tagRegex.FindAllStringIndex(content, -1) {
+		text := content[last:loc[0]]
+		if skip == 0 {
+			text = tcyText(text)
+		}
+		out.WriteString(text)
+
+		tag := content[loc[0]:loc[1]]
+		out.WriteString(tag)
+		m := tcySkipRegex.FindStringSubmatch(tag)
+		switch {
+		case m == nil || strings.HasSuffix(tag, "/>"):
+		case m[2] != "span":
+			if m[1] == "/" {
+				skip--
+			} else {
+				skip++
+			}
+		case m[1] == "/":
+			// closing tags don't say which span they close, so
+			// every span inside a tcy span is counted
+			if spans > 0 {
+				spans--
+				if spans == 0 {
+					skip--
+				}
+			}
+		case spans > 0 || strings.Contains(tag, `class="tcy"`):
+			if spans == 0 {
+				skip++
+			}
+			spans++
+		}
+		last = loc[1]
+	}
+	if skip == 0 {
+		out.WriteString(tcyText(content[last:]))
+	} else {
+		out.WriteString(content[last:])
+	}
+	return out.String()
+}
+
+var tcySkipRegex = regexp.MustCompile(`^<(/?)(rt|rp|script|style|span)\b`)
+
+func tcyText(text string) string {
+	out := &strings.Builder{}
+	for i := 0; i < len(text); {
+		j := i
+		switch {
+		case isDigit(text[i]):
+			for j < len(text) && isDigit(text[j]) {
+				j++
+			}
+		case isMark(text[i]):
+			for j < len(text) && isMark(text[j]) {
+				j++
+			}
+		default:
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+		run := text[i:j]
+		upright := len(run) == 2 || (len(run) == 3 && isDigit(run[0]))
+		if i > 0 && tcyJoined(text[i-1]) || j < len(text) && tcyJoined(text[j]) {
+			upright = false
+		}
+		if upright {
+			out.WriteString(`<span class="tcy">` + run + `</span>`)
+		} else {
+			out.WriteString(run)
+		}
+		i = j
+	}
+	return out.String()
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+func isMark(b byte) bool {
+	return b == '!' || b == '?'
+}
+
+// tcyJoined reports whether b makes a neighbouring number part of
+// something longer, like a decimal, a time, or a character entity.
+func tcyJoined(b byte) bool {
+	return isDigit(b) || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') ||
+		strings.IndexByte(".,:/#&;", b) >= 0
+}
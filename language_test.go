@@ -0,0 +1,84 @@
+package epub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetLanguage(t *testing.T) {
+	tests := []struct {
+		tag   string
+		valid bool
+	}{
+		{"en", true},
+		{"ar-EG", true},
+		{"zh-Hant-TW", true},
+		{"x-pig-latin", true},
+		{"i-klingon", true},
+		{"en_US", false},
+		{"e", false},
+		{"en-", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		e := NewBook("Test Book")
+		err := e.SetLanguage(tt.tag)
+		if (err == nil) != tt.valid {
+			t.Errorf("SetLanguage(%q) returned %v", tt.tag, err)
+		}
+	}
+}
+
+func TestTateChuYoko(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"<p>12月25日</p>", `<p><span class="tcy">12</span>月<span class="tcy">25</span>日</p>`},
+		{"<p>第100話</p>", `<p>第<span class="tcy">100</span>話</p>`},
+		{"<p>2024年</p>", "<p>2024年</p>"},
+		{"<p>本当!?</p>", `<p>本当<span class="tcy">!?</span></p>`},
+		{"<p>3.14 10:30 &#12345;</p>", "<p>3.14 10:30 &#12345;</p>"},
+		{`<p><img src="img_12.png" alt="12"/>12</p>`, `<p><img src="img_12.png" alt="12"/><span class="tcy">12</span></p>`},
+		{"<ruby>月<rp>(</rp><rt>12</rt><rp>)</rp></ruby>", "<ruby>月<rp>(</rp><rt>12</rt><rp>)</rp></ruby>"},
+		// text after an upright span is still set upright
+		{`<p><span class="tcy">12</span>月25日</p>`, `<p><span class="tcy">12</span>月<span class="tcy">25</span>日</p>`},
+		{`<p><span class="tcy"><span>12</span></span>月25日</p>`, `<p><span class="tcy"><span>12</span></span>月<span class="tcy">25</span>日</p>`},
+		{`<p><span class="em">12</span>月</p>`, `<p><span class="em"><span class="tcy">12</span></span>月</p>`},
+	}
+	for _, tt := range tests {
+		if got := tateChuYoko(tt.in); got != tt.want {
+			t.Errorf("tateChuYoko(%q) =\n%s\nwant\n%s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRuby(t *testing.T) {
+	e := NewBook("Test Book")
+	must(t, e.SetLanguage("ja"))
+	must(t, e.SetWritingMode(Vertical))
+	must(t, e.AddChapterMD("One", "{漢字|かんじ}と{東京|とう|きょう}、12時。"))
+	b := buildValid(t, e)
+	wantText(t, b, []string{
+		"<ruby>漢字<rp>(</rp><rt>かんじ</rt><rp>)</rp></ruby>",
+		"<ruby>東<rp>(</rp><rt>とう</rt><rp>)</rp>京<rp>(</rp><rt>きょう</rt><rp>)</rp></ruby>",
+		`<span class="tcy">12</span>時`,
+		`page-progression-direction="rtl"`,
+		`<meta name="primary-writing-mode" content="vertical-rl"`,
+	})
+}
+
+func TestWritingModeHorizontalAgain(t *testing.T) {
+	e := NewBook("Test Book")
+	must(t, e.SetWritingMode(Vertical))
+	must(t, e.SetWritingMode(Horizontal))
+	must(t, e.AddChapterMD("One", "{漢字|かんじ} 12."))
+	b := buildValid(t, e)
+	wantText(t, b, []string{`page-progression-direction="ltr"`, "{漢字|かんじ} 12."})
+	for name, doc := range documents(t, b) {
+		for _, s := range []string{"<ruby>", `class="tcy"`, "vertical.css", "primary-writing-mode"} {
+			if strings.Contains(doc, s) {
+				t.Errorf("%s still has %s", name, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package epub
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var linkRegex = regexp.MustCompile(`href="(#chapter:|ref:|#source:)([^"]*)"`)
+
+// resolveLinks points links written as "#chapter:<title slug>" at
+// the first page of the section with that title, and links written
+// as "ref:<id>" at the page holding the element with that id, or
+// the heading with that slug. "#chapter:<title slug>#<id>" looks for
+// the id only in that section. Links between the documents of an
+// opened epub are written as "#source:<path>#<id>" and point at the
+// page the document became. Every link that can't be resolved is
+// listed in the error.
+func resolveLinks(pages []*bookPage) error {
+	refs := make(map[string]bool)
+	for _, page := range pages {
+		for _, m := range linkRegex.FindAllStringSubmatch(page.args.Content, -1) {
+			if m[1] == "ref:" {
+				refs[m[2]] = true
+			} else if _, fragment, ok := strings.Cut(m[2], "#"); ok && m[1] == "#chapter:" {
+				refs[fragment] = true
+			}
+		}
+	}
+	if len(refs) > 0 {
+		headingIDs(pages, refs)
+	}
+
+	// chapters holds the pages of the first section with each slug
+	chapters := make(map[string][]*bookPage)
+	anchors := make(map[string]string)
+	sources := make(map[string]*bookPage)
+	for i, page := range pages {
+		name := page.args.ID
+		for _, source := range page.sources {
+			sources[source] = page
+		}
+		if page.args.Header && page.slug != "" {
+			if _, ok := chapters[page.slug]; !ok {
+				end := i + 1
+				for end < len(pages) && !pages[end].args.Header {
+					end++
+				}
+				chapters[page.slug] = pages[i:end]
+			}
+		}
+		for _, m := range idAttrRegex.FindAllStringSubmatch(page.args.Content, -1) {
+			if _, ok := anchors[m[1]]; !ok {
+				anchors[m[1]] = name + "#" + m[1]
+			}
+		}
+	}
+
+	unresolved := []string{}
+	for _, page := range pages {
+		page.args.Content = linkRegex.ReplaceAllStringFunc(page.args.Content, func(link string) string {
+			m := linkRegex.FindStringSubmatch(link)
+			target, ok := anchors[m[2]]
+			switch m[1] {
+			case "#chapter:":
+				slug, fragment, _ := strings.Cut(m[2], "#")
+				target, ok = sectionTarget(chapters[slug], fragment)
+			case "#source:":
+				source, fragment, _ := strings.Cut(m[2], "#")
+				target, ok = sourceTarget(sources[source], fragment)
+			}
+			if !ok {
+				unresolved = append(unresolved, page.args.ID+": "+m[1]+m[2])
+				return link
+			}
+			return `href="../text/` + target + `"`
+		})
+	}
+	if len(unresolved) > 0 {
+		return errors.Errorf("Unresolved links:\n  %s", strings.Join(unresolved, "\n  "))
+	}
+	return nil
+}
+
+// sectionTarget returns the page of section holding the element
+// with id fragment, or its first page when fragment is empty.
+func sectionTarget(section []*bookPage, fragment string) (string, bool) {
+	if len(section) == 0 {
+		return "", false
+	}
+	if fragment == "" {
+		return section[0].args.ID, true
+	}
+	for _, page := range section {
+		for _, m := range idAttrRegex.FindAllStringSubmatch(page.args.Content, -1) {
+			if m[1] == fragment {
+				return page.args.ID + "#" + fragment, true
+			}
+		}
+	}
+	return "", false
+}
+
+// sourceTarget returns the page an opened document became, with
+// the element with id fragment when it's still there. Headings
+// replaced by the generated title lose their ids.
+func sourceTarget(page *bookPage, fragment string) (string, bool) {
+	if page == nil {
+		return "", false
+	}
+	if fragment != "" {
+		for _, m := range idAttrRegex.FindAllStringSubmatch(page.args.Content, -1) {
+			if m[1] == fragment {
+				return page.args.ID + "#" + fragment, true
+			}
+		}
+	}
+	return page.args.ID, true
+}
+
+// headingIDs gives the first heading whose slug is in refs an id,
+// unless an element already has that id, so "ref:<slug>" can link
+// to headings without one.
+func headingIDs(pages []*bookPage, refs map[string]bool) {
+	for _, page := range pages {
+		for _, m := range idAttrRegex.FindAllStringSubmatch(page.args.Content, -1) {
+			delete(refs, m[1])
+		}
+	}
+	for _, page := range pages {
+		page.args.Content = headingRegex.ReplaceAllStringFunc(page.args.Content, func(h string) string {
+			m := headingRegex.FindStringSubmatch(h)
+			slug := slugify(html.UnescapeString(tagRegex.ReplaceAllString(m[3], "")))
+			if !refs[slug] || idAttrRegex.MatchString(m[2]) {
+				return h
+			}
+			delete(refs, slug)
+			return fmt.Sprintf(`<h%s id="%s"%s>%s</h%s>`, m[1], slug, m[2], m[3], m[1])
+		})
+	}
+}
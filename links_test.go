@@ -0,0 +1,50 @@
+package epub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveLinks(t *testing.T) {
+	e := NewBook("Test Book")
+	must(t, e.AddChapterMD("One", `See [two](#chapter:two), [later](#chapter:two#later),
+[the pair](ref:tom-jerry), and [the note](ref:note).`))
+	must(t, e.AddChapterHTML("Two", []string{"<p>Text.</p>", "<h2>Later</h2><p>More.</p>"}))
+	must(t, e.AddChapterHTML("Three", []string{`<h2>Tom &amp; Jerry</h2><p id="note">A note.</p>`}))
+	must(t, e.AddChapterMD("Two", "A second chapter with the same title."))
+	docs := documents(t, buildValid(t, e))
+
+	one := ""
+	for _, doc := range docs {
+		if strings.Contains(doc, "See <a") {
+			one = doc
+		}
+	}
+	for _, want := range []string{
+		`href="../text/chapter002-0.xhtml"`,
+		`href="../text/chapter002-1.xhtml#later"`,
+		`href="../text/chapter003-0.xhtml#tom-jerry"`,
+		`href="../text/chapter003-0.xhtml#note"`,
+	} {
+		if !strings.Contains(one, want) {
+			t.Errorf("Chapter one is missing %s:\n%s", want, one)
+		}
+	}
+	if !strings.Contains(docs["OEBPS/text/chapter003-0.xhtml"], `<h2 id="tom-jerry">Tom &amp; Jerry</h2>`) {
+		t.Error("Linked heading wasn't given an id")
+	}
+}
+
+func TestUnresolvedLinks(t *testing.T) {
+	e := NewBook("Test Book")
+	must(t, e.AddChapterMD("One", "See [three](#chapter:three), [later](#chapter:one#later), and [x](ref:missing)."))
+	_, err := e.Bytes()
+	if err == nil {
+		t.Fatal("Built a book with unresolved links")
+	}
+	for _, want := range []string{"#chapter:three", "#chapter:one#later", "ref:missing"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Error %q doesn't list %s", err, want)
+		}
+	}
+}
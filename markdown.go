@@ -2,12 +2,20 @@ package epub
 
 import (
 	"bytes"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 
 	"github.com/cahaba-ts/epub/shortcode"
+	"github.com/pkg/errors"
 	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
 )
 
 var (
@@ -37,6 +45,9 @@ func (e *Book) renderMarkdown(content string) ([]string, error) {
 		e.md = goldmark.New(
 			goldmark.WithExtensions(e.exts...),
 			goldmark.WithExtensions(shortcode.Extension),
+			goldmark.WithParserOptions(
+				parser.WithASTTransformers(util.Prioritized(imageResolver{}, 100)),
+			),
 			goldmark.WithRendererOptions(
 				html.WithXHTML(),
 			),
@@ -44,12 +55,19 @@ func (e *Book) renderMarkdown(content string) ([]string, error) {
 	}
 	lock.Lock()
 	current = e
+	e.imageErrs = nil
 	buf := &bytes.Buffer{}
 	err := e.md.Convert([]byte(content), buf)
 	lock.Unlock()
 	if err != nil {
 		return nil, err
 	}
+	if len(e.imageErrs) > 0 {
+		return nil, errors.Errorf("Unresolved images: %s", strings.Join(e.imageErrs, ", "))
+	}
+	// left behind by shortcodes that close the paragraph
+	out := strings.ReplaceAll(buf.String(), "<p></p>", "")
+	buf = bytes.NewBufferString(out)
 
 	// must break into multiple sections
 	if !strings.Contains(buf.String(), "<!-- PAGE BREAK -->") {
@@ -70,3 +88,75 @@ func (e *Book) renderMarkdown(content string) ([]string, error) {
 
 	return good, nil
 }
+
+// SetSourceDir sets the directory markdown image paths are relative
+// to. Images found there that weren't added with AddImage are added
+// when the markdown is rendered.
+func (e *Book) SetSourceDir(dir string) {
+	e.Lock()
+	e.sourceDir = dir
+	e.Unlock()
+}
+
+// imageResolver points markdown image destinations at the images
+// and assets added to the book. It also marks the shortcodes that
+// are directly inside a paragraph, so block shortcodes know to
+// close it.
+type imageResolver struct{}
+
+func (imageResolver) Transform(doc *ast.Document, _ text.Reader, _ parser.Context) {
+	e := current
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering && n.Kind() == shortcode.KindShortcode && n.Parent().Kind() == ast.KindParagraph {
+			n.SetAttributeString(inParagraph, true)
+		}
+		img, ok := n.(*ast.Image)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		dest := string(img.Destination)
+		resolved, err := e.resolveImage(dest)
+		if err != nil {
+			e.imageErrs = append(e.imageErrs, dest)
+			return ast.WalkContinue, nil
+		}
+		img.Destination = []byte(resolved)
+		return ast.WalkContinue, nil
+	})
+}
+
+// resolveImage returns the path of the image or asset dest refers
+// to, adding it from the source directory if needed. The caller
+// must hold the lock.
+func (e *Book) resolveImage(dest string) (string, error) {
+	if dest == "" || strings.Contains(dest, ":") || strings.HasPrefix(dest, "#") ||
+		strings.HasPrefix(dest, "../images/") || strings.HasPrefix(dest, "../assets/") {
+		// external, or already resolved
+		return dest, nil
+	}
+	name := dest
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	name = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(name)), "./")
+	if p, ok := e.imageLookup[name]; ok {
+		return p, nil
+	}
+	if p, ok := e.assetLookup[name]; ok {
+		return p, nil
+	}
+
+	if e.sourceDir == "" {
+		return "", errors.Errorf("Image not found: %s", dest)
+	}
+	f, err := os.Open(filepath.Join(e.sourceDir, filepath.FromSlash(name)))
+	if err != nil {
+		return "", errors.Wrap(err, "Image not found")
+	}
+	defer f.Close()
+	mediaType, ok := ImageMediaTypes[strings.ToLower(filepath.Ext(name))]
+	if !ok {
+		return "", errors.Errorf("Unsupported image type: %s", dest)
+	}
+	return e.storeImage(f, name, mediaType)
+}
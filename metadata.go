@@ -0,0 +1,154 @@
+package epub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MARC relator codes for the most common creator and contributor
+// roles. Any code from https://id.loc.gov/vocabulary/relators can
+// be used.
+const (
+	RoleAuthor      = "aut"
+	RoleEditor      = "edt"
+	RoleIllustrator = "ill"
+	RoleTranslator  = "trl"
+	RoleCoverArtist = "cov"
+	RoleNarrator    = "nrt"
+)
+
+type bookCreator struct {
+	ID         string
+	Name       string
+	Role       string
+	FileAs     string
+	DisplaySeq int
+}
+
+// AddCreator adds a dc:creator, such as a second author or the
+// illustrator of a light novel. role is a MARC relator code and
+// fileAs is the name used for sorting, like "Doe, Jane". Both are
+// optional. Creators are listed in the order they are added.
+func (e *Book) AddCreator(name, role, fileAs string) {
+	e.args.Creators = append(e.args.Creators, bookCreator{
+		Name:   name,
+		Role:   role,
+		FileAs: fileAs,
+	})
+	e.updateCreators()
+}
+
+// AddContributor adds a dc:contributor, for people with a smaller
+// part in the book, like an editor. The arguments are the same as
+// AddCreator.
+func (e *Book) AddContributor(name, role, fileAs string) {
+	e.args.Contributors = append(e.args.Contributors, bookCreator{
+		Name:   name,
+		Role:   role,
+		FileAs: fileAs,
+	})
+	e.updateCreators()
+}
+
+// updateCreators numbers the creators and contributors and keeps
+// Author in sync with the creators that have the author role.
+func (e *Book) updateCreators() {
+	authors := []string{}
+	for i := range e.args.Creators {
+		c := &e.args.Creators[i]
+		c.ID = fmt.Sprintf("creator%02d", i+1)
+		c.DisplaySeq = i + 1
+		if c.Role == RoleAuthor {
+			authors = append(authors, c.Name)
+		}
+	}
+	for i := range e.args.Contributors {
+		c := &e.args.Contributors[i]
+		c.ID = fmt.Sprintf("contributor%02d", i+1)
+		c.DisplaySeq = i + 1
+	}
+	e.args.Author = strings.Join(authors, ", ")
+}
+
+// Series returns the series name and the book's position in it.
+func (e *Book) Series() (string, float64) {
+	index, _ := strconv.ParseFloat(e.args.SeriesIndex, 64)
+	return e.args.Series, index
+}
+
+// SetSeries sets the series the book belongs to and its position
+// in that series, such as 2 or 2.5 for a side story. It is written
+// both as an epub3 belongs-to-collection and as the calibre:series
+// metas that older readers use. An empty name removes the series.
+func (e *Book) SetSeries(name string, index float64) {
+	e.args.Series = name
+	e.args.SeriesIndex = ""
+	if name != "" && index > 0 {
+		e.args.SeriesIndex = strconv.FormatFloat(index, 'f', -1, 64)
+	}
+}
+
+type bookSubject struct {
+	ID        string
+	Name      string
+	Authority string
+	Term      string
+}
+
+// AddSubject adds a dc:subject. For a keyword leave authority and
+// term empty. For a classification, authority is the scheme, like
+// "BISAC" or "THEMA", and term is its code, like "FIC009020".
+func (e *Book) AddSubject(subject, authority, term string) {
+	e.args.Subjects = append(e.args.Subjects, bookSubject{
+		ID:        fmt.Sprintf("subject%02d", len(e.args.Subjects)+1),
+		Name:      subject,
+		Authority: authority,
+		Term:      term,
+	})
+}
+
+// Rights returns the dc:rights statement.
+func (e *Book) Rights() string {
+	return e.args.Rights
+}
+
+// SetRights sets the dc:rights statement, such as
+// "Copyright © 2024 Jane Doe. All rights reserved."
+func (e *Book) SetRights(rights string) {
+	e.args.Rights = rights
+}
+
+type bookMeta struct {
+	Property string
+	Value    string
+	Refines  string
+}
+
+// AddMeta adds any other epub3 meta to the package metadata. refines
+// is the optional id of the element it refines, with or without the
+// leading "#".
+func (e *Book) AddMeta(property, value, refines string) {
+	if refines != "" && !strings.HasPrefix(refines, "#") {
+		refines = "#" + refines
+	}
+	e.args.Metas = append(e.args.Metas, bookMeta{
+		Property: property,
+		Value:    value,
+		Refines:  refines,
+	})
+}
+
+type bookLink struct {
+	Rel  string
+	Href string
+}
+
+// AddLink adds a link to the package metadata, such as a record
+// with rel "record" or an alternate edition.
+func (e *Book) AddLink(rel, href string) {
+	e.args.Links = append(e.args.Links, bookLink{
+		Rel:  rel,
+		Href: href,
+	})
+}
@@ -0,0 +1,60 @@
+package epub
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMetadataEscaping(t *testing.T) {
+	e := NewBook("Salt & Pepper <Unabridged>")
+	must(t, e.SetLanguage("en-US"))
+	e.AddCreator("Smith & Wesson", RoleAuthor, "Wesson, Smith & Co")
+	e.AddContributor("Ed <Jr>", "a&b", "")
+	e.SetDescription(`A "tale" of <two> cities & more`)
+	e.SetPublisher("Black & White Press")
+	e.SetRights("© Smith & Wesson")
+	e.SetSeries("Tales & Legends", 2)
+	e.AddSubject("Cooking & Food", "BISAC", "CKB000000")
+	e.AddMeta("dcterms:source", "Notes & Queries", "")
+	e.AddLink("record", "https://example.com/?a=1&b=2")
+	must(t, e.AddTitlePage())
+	must(t, e.AddChapterMD("One", "Text."))
+
+	b := buildValid(t, e)
+	opf := documents(t, b)["OEBPS/content.opf"]
+	for _, want := range []string{
+		"<dc:title>Salt &amp; Pepper &lt;Unabridged&gt;</dc:title>",
+		`property="role" scheme="marc:relators">a&amp;b</meta>`,
+		`href="https://example.com/?a=1&amp;b=2"`,
+	} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("content.opf is missing %s", want)
+		}
+	}
+	// every metadata element is on its own line
+	metadata := opf[strings.Index(opf, "<metadata"):strings.Index(opf, "</metadata>")]
+	for _, line := range strings.Split(strings.TrimSpace(metadata), "\n")[1:] {
+		if !strings.HasPrefix(line, "    <") || strings.HasPrefix(line, "     ") {
+			t.Errorf("Misplaced metadata line %q", line)
+		}
+	}
+
+	opened, err := OpenReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	series, _ := opened.Series()
+	for _, v := range []struct{ got, want string }{
+		{opened.Title(), e.Title()},
+		{opened.Author(), e.Author()},
+		{opened.Description(), e.Description()},
+		{opened.Publisher(), e.Publisher()},
+		{opened.Rights(), e.Rights()},
+		{series, "Tales & Legends"},
+	} {
+		if v.got != v.want {
+			t.Errorf("Opened %q, want %q", v.got, v.want)
+		}
+	}
+}
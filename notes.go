@@ -0,0 +1,187 @@
+package epub
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/yuin/goldmark/extension"
+)
+
+// NoteMode is where markdown footnotes are placed.
+type NoteMode string
+
+const (
+	// NotesOff leaves footnotes to any extension added with
+	// AddMDExtension.
+	NotesOff NoteMode = ""
+	// Footnotes are popup footnotes at the end of the page that
+	// first references them.
+	Footnotes NoteMode = "footnotes"
+	// Endnotes are gathered into a Notes section at the end of
+	// the book.
+	Endnotes NoteMode = "endnotes"
+)
+
+var (
+	footnoteListRegex = regexp.MustCompile(`(?s)<div class="footnotes" role="doc-endnotes">\s*<hr />\s*<ol>\s*(.*?)</ol>\s*</div>`)
+	footnoteItemRegex = regexp.MustCompile(`<li id="fn:(\d+)">\s*`)
+	footnoteRefRegex  = regexp.MustCompile(`<sup id="fnref(\d*):(\d+)"><a href="#fn:\d+" class="footnote-ref" role="doc-noteref">(\d+)</a></sup>`)
+	footnoteBackRegex = regexp.MustCompile(`&#160;<a href="#fnref\d*:\d+" class="footnote-backref" role="doc-backlink">[^<]*</a>`)
+)
+
+// SetNotes turns on markdown footnotes, written as "[^1]" and
+// "[^1]: The note.", and sets where they are placed. Set it before
+// adding any markdown sections.
+func (e *Book) SetNotes(mode NoteMode) error {
+	switch mode {
+	case NotesOff, Footnotes, Endnotes:
+	default:
+		return errors.Errorf("Invalid note mode: %q", mode)
+	}
+	e.Lock()
+	defer e.Unlock()
+	if mode != NotesOff && e.notes == NotesOff {
+		e.exts = append(e.exts, extension.Footnote)
+		// rebuilt with the new extension on the next render
+		e.md = nil
+	}
+	e.notes = mode
+	return nil
+}
+
+// bookNote is a footnote found while building a section.
+type bookNote struct {
+	ID      string
+	Ref     string
+	File    string
+	Content string
+}
+
+// endnoteGroup is the endnotes of one section.
+type endnoteGroup struct {
+	Title string
+	Notes []bookNote
+}
+
+// placeNotes takes the footnotes goldmark put at the end of a
+// section's last page and rewrites their references to point at
+// the notes' new place. names are the filenames of the pages.
+func (e *Book) placeNotes(parts, names []string, title string) []string {
+	if e.notes == NotesOff || len(parts) == 0 {
+		return parts
+	}
+	last := len(parts) - 1
+	list := footnoteListRegex.FindStringSubmatch(parts[last])
+	if list == nil {
+		return parts
+	}
+	parts = append([]string{}, parts...)
+	parts[last] = strings.Replace(parts[last], list[0], "", 1)
+
+	// the content of each note runs until the next one starts
+	notes := make(map[string]*bookNote)
+	order := []string{}
+	starts := footnoteItemRegex.FindAllStringSubmatchIndex(list[1], -1)
+	for i, s := range starts {
+		end := len(list[1])
+		if i+1 < len(starts) {
+			end = starts[i+1][0]
+		}
+		n := list[1][s[2]:s[3]]
+		content := strings.TrimSpace(list[1][s[1]:end])
+		content = strings.TrimSpace(strings.TrimSuffix(content, "</li>"))
+		notes[n] = &bookNote{
+			ID:      fmt.Sprintf("note-%d-%s", e.sectionCount, n),
+			Content: footnoteBackRegex.ReplaceAllString(content, ""),
+		}
+		order = append(order, n)
+	}
+
+	target := func(note *bookNote) string {
+		if e.notes == Endnotes {
+			return e.notesFile + "#" + note.ID
+		}
+		return note.File + "#" + note.ID
+	}
+	for i := range parts {
+		placed := []*bookNote{}
+		parts[i] = footnoteRefRegex.ReplaceAllStringFunc(parts[i], func(ref string) string {
+			m := footnoteRefRegex.FindStringSubmatch(ref)
+			note, ok := notes[m[2]]
+			if !ok {
+				return ref
+			}
+			id := fmt.Sprintf("noteref-%d-%s", e.sectionCount, m[2])
+			if m[1] != "" {
+				id += "-" + m[1]
+			}
+			if note.Ref == "" {
+				note.Ref = id
+				note.File = names[i]
+				placed = append(placed, note)
+			}
+			href := target(note)
+			if note.File == names[i] && e.notes == Footnotes {
+				href = "#" + note.ID
+			}
+			return fmt.Sprintf(
+				`<sup id="%s"><a href="%s" class="cahaba--noteref" epub:type="noteref" role="doc-noteref">%s</a></sup>`,
+				id, href, m[3],
+			)
+		})
+		if e.notes != Footnotes {
+			continue
+		}
+		for _, note := range placed {
+			parts[i] += fmt.Sprintf(
+				"\n<aside id=\"%s\" class=\"cahaba--footnote\" epub:type=\"footnote\" role=\"doc-footnote\">\n%s\n</aside>",
+				note.ID, withBacklink(note.Content, "#"+note.Ref),
+			)
+		}
+	}
+
+	if e.notes == Endnotes {
+		group := endnoteGroup{Title: title}
+		for _, n := range order {
+			if note := notes[n]; note.Ref != "" {
+				group.Notes = append(group.Notes, *note)
+			}
+		}
+		if len(group.Notes) > 0 {
+			e.endnotes = append(e.endnotes, group)
+		}
+	}
+	return parts
+}
+
+// withBacklink adds a link back to the reference at the end of the
+// note's last paragraph.
+func withBacklink(content, href string) string {
+	link := fmt.Sprintf(`&#160;<a href="%s" class="cahaba--backlink" epub:type="backlink" role="doc-backlink">&#x21a9;&#xfe0e;</a>`, href)
+	if strings.HasSuffix(content, "</p>") {
+		return strings.TrimSuffix(content, "</p>") + link + "</p>"
+	}
+	return content + link
+}
+
+// endnotesSection returns the Notes section holding every endnote.
+func (e *Book) endnotesSection() epubSection {
+	b := &strings.Builder{}
+	for _, group := range e.endnotes {
+		fmt.Fprintf(b, "<h2 class=\"cahaba--endnotes-title\">%s</h2>\n<ol class=\"cahaba--endnotes\">\n", xmlEscape(group.Title))
+		for _, note := range group.Notes {
+			fmt.Fprintf(
+				b, "<li id=\"%s\" epub:type=\"endnote\" role=\"doc-endnote\">\n%s\n</li>\n",
+				note.ID, withBacklink(note.Content, note.File+"#"+note.Ref),
+			)
+		}
+		b.WriteString("</ol>\n")
+	}
+	return epubSection{
+		title:       "Notes",
+		parts:       []string{b.String()},
+		sectionType: "endnotes",
+	}
+}
@@ -0,0 +1,70 @@
+package epub
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFootnotes(t *testing.T) {
+	e := NewBook("Test Book")
+	must(t, e.SetNotes(Footnotes))
+	must(t, e.AddChapterMD("One", "A claim.[^1] Another.[^2] The first again.[^1]\n\n[^1]: The source.\n\n[^2]: The *other* source."))
+	b := buildValid(t, e)
+
+	chapter := ""
+	for name, doc := range documents(t, b) {
+		if strings.Contains(doc, "A claim.") {
+			chapter = doc
+		}
+		if strings.Contains(doc, `class="footnotes"`) {
+			t.Errorf("%s still has goldmark's footnote list", name)
+		}
+	}
+	for _, want := range []string{
+		`<sup id="noteref-1-1"><a href="#note-1-1" class="cahaba--noteref" epub:type="noteref" role="doc-noteref">1</a></sup>`,
+		`<sup id="noteref-1-1-1"><a href="#note-1-1"`,
+		`<aside id="note-1-1" class="cahaba--footnote" epub:type="footnote" role="doc-footnote">`,
+		`<p>The <em>other</em> source.&#160;<a href="#noteref-1-2" class="cahaba--backlink"`,
+	} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("Chapter is missing %s:\n%s", want, chapter)
+		}
+	}
+	if n := strings.Count(chapter, "<aside"); n != 2 {
+		t.Errorf("Chapter has %d footnotes, want 2", n)
+	}
+}
+
+func TestEndnotes(t *testing.T) {
+	e := NewBook("Test Book")
+	must(t, e.SetNotes(Endnotes))
+	must(t, e.AddChapterMD("Salt & Pepper", "A claim.[^1]\n\n[^1]: The source."))
+	must(t, e.AddChapterMD("No Notes", "Nothing to cite."))
+	must(t, e.AddChapterMD("<Three>", "Another claim.[^1]\n\n[^1]: The other source."))
+	b := buildValid(t, e)
+
+	notes := ""
+	for _, doc := range documents(t, b) {
+		if strings.Contains(doc, "cahaba--endnotes") && strings.Contains(doc, "<ol") {
+			notes = doc
+		}
+	}
+	titles := regexp.MustCompile(`<h2 class="cahaba--endnotes-title">(.*?)</h2>`).FindAllStringSubmatch(notes, -1)
+	if len(titles) != 2 || titles[0][1] != "Salt &amp; Pepper" || titles[1][1] != "&lt;Three&gt;" {
+		t.Errorf("Got endnote titles %q, want the escaped titles of the chapters with notes", titles)
+	}
+	if n := strings.Count(notes, `epub:type="endnote"`); n != 2 {
+		t.Errorf("Notes section has %d endnotes, want 2", n)
+	}
+	wantText(t, b, []string{`#note-1-1" class="cahaba--noteref"`, `#noteref-1-1" class="cahaba--backlink"`})
+}
+
+func TestSetNotes(t *testing.T) {
+	e := NewBook("Test Book")
+	if err := e.SetNotes("sidenotes"); err == nil {
+		t.Error("SetNotes accepted an unknown mode")
+	}
+	must(t, e.AddChapterMD("One", "A claim.[^1]\n\n[^1]: The source."))
+	wantText(t, buildValid(t, e), []string{"[^1]"})
+}
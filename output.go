@@ -2,11 +2,14 @@ package epub
 
 import (
 	"archive/zip"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"text/template"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -17,32 +20,228 @@ var (
 	mtOPF   = "application/opf+xml"
 )
 
+// Write builds the book and writes the finished epub to filename.
 func (e *Book) Write(filename string) error {
 	fmt.Println("Building: ", filename)
-	// write cover.xhtml
-	err := e.execTemplate("cover.xhtml", "OEBPS/text/cover.xhtml", mtXHTML)
+	f, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
+	if _, err := e.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteTo builds the book and writes the finished epub to w.
+// Nothing is written to disk unless Calibre polish is enabled,
+// so books can be built concurrently and served directly. The book
+// is left as it was, so it can be changed and written again.
+func (e *Book) WriteTo(w io.Writer) (int64, error) {
+	e.Lock()
+	staged, modified, err := e.build()
+	polish := e.polish
+	e.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	cw := &countingWriter{w: w}
+	if polish {
+		err = writePolished(cw, staged, modified)
+	} else {
+		err = finalize(cw, staged, modified)
+	}
+	return cw.n, err
+}
+
+// Bytes builds the book and returns the finished epub.
+func (e *Book) Bytes() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if _, err := e.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// buildState is what a build changes on the book, put back once
+// it's done.
+type buildState struct {
+	args         bookArgs
+	imageLookup  map[string]string
+	sectionCount int
+	navPoint     int
+	endnotes     []endnoteGroup
+}
+
+func (e *Book) saveState() buildState {
+	s := buildState{
+		args:         *e.args,
+		imageLookup:  make(map[string]string, len(e.imageLookup)),
+		sectionCount: e.sectionCount,
+		navPoint:     e.navPoint,
+		endnotes:     e.endnotes,
+	}
+	s.args.Files = append([]bookFile{}, e.args.Files...)
+	if a := e.args.Accessibility; a != nil {
+		saved := *a
+		s.args.Accessibility = &saved
+	}
+	for k, v := range e.imageLookup {
+		s.imageLookup[k] = v
+	}
+	return s
+}
+
+func (e *Book) restoreState(s buildState) {
+	*e.args = s.args
+	e.imageLookup = s.imageLookup
+	e.sectionCount = s.sectionCount
+	e.navPoint = s.navPoint
+	e.endnotes = s.endnotes
+	e.pages = nil
+	e.frontPages = 0
+	e.notesFile = ""
+	e.file = nil
+}
+
+// build renders every template and section into a new staging
+// archive and returns it with the time the book was modified. The
+// book is left as it was. The caller must hold the lock.
+func (e *Book) build() (*bytes.Buffer, time.Time, error) {
+	defer e.restoreState(e.saveState())
+	modified := e.modifiedTime()
+	buf := &bytes.Buffer{}
+	if err := e.buildArchive(buf, modified); err != nil {
+		return nil, modified, err
+	}
+	return buf, modified, nil
+}
+
+// buildArchive writes the staged files, every template, and every
+// section to w.
+func (e *Book) buildArchive(w io.Writer, modified time.Time) error {
+	e.file = zip.NewWriter(w)
+	for _, f := range e.staged {
+		if err := e.createFile(f.name, f.data); err != nil {
+			return err
+		}
+	}
+
+	e.args.CurrentDate = modified.Format(time.RFC3339)
+	e.args.Direction = e.direction()
+	e.args.URN = e.Identifier()
+	e.args.ISBN = e.isbn()
+	e.args.Year = e.releaseYear()
+	if e.args.CoverImage == "" && !e.generatedCover.Disabled {
+		if err := e.generateCover(); err != nil {
+			return err
+		}
+	}
+	if a := e.args.Accessibility; a != nil && len(a.AccessModes) == 0 {
+		a.AccessModes = e.accessModes()
+	}
+
+	if e.args.VerticalStylesheet != "" {
+		if err := e.execTemplate("vertical.css", "OEBPS/vertical.css", "text/css"); err != nil {
+			return err
+		}
+	}
+
+	// write cover.xhtml
+	if e.args.CoverImage != "" {
+		if err := e.execTemplate("cover.xhtml", "OEBPS/text/cover.xhtml", mtXHTML); err != nil {
+			return err
+		}
+	}
+
+	if e.args.Cover != "" {
+		e.addLandmark("cover", "cover", "Cover", "OEBPS/text/cover.xhtml")
+	}
+	e.addLandmark("toc", "toc", "Table of Contents", "OEBPS/text/nav.xhtml#toc")
+
+	if e.notes == Endnotes {
+		// the Notes section comes after every other section
+		total := len(e.sections[0]) + len(e.sections[1]) + len(e.sections[2])
+		e.notesFile = fmt.Sprintf("chapter%03d-0.xhtml", e.sectionCount+total+1)
+	}
 
 	// write sections
 	for _, section := range e.sections[0] {
-		err = e.buildSection(section, "introduction")
+		chapter, err := e.buildSection(section, "introduction")
 		if err != nil {
 			return err
 		}
+		e.args.Chapters = append(e.args.Chapters, chapter)
 	}
-	for _, section := range e.sections[1] {
-		err = e.buildSection(section, "chapter")
+	e.frontPages = len(e.pages)
+	// chapters after a part are nested under it
+	part := -1
+	for i, section := range e.sections[1] {
+		chapter, err := e.buildSection(section, "chapter")
 		if err != nil {
 			return err
 		}
+		if i == 0 {
+			e.addLandmark("bodymatter", "text", "Begin Reading", chapter.Path)
+		}
+		switch {
+		case section.sectionType == "part":
+			e.args.Chapters = append(e.args.Chapters, chapter)
+			part = len(e.args.Chapters) - 1
+		case part >= 0:
+			e.args.Chapters[part].Children = append(e.args.Chapters[part].Children, chapter)
+		default:
+			e.args.Chapters = append(e.args.Chapters, chapter)
+		}
+	}
+	for i, section := range e.sections[2] {
+		chapter, err := e.buildSection(section, "postscript")
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			e.addLandmark("backmatter", "other.backmatter", "Back Matter", chapter.Path)
+		}
+		e.args.Chapters = append(e.args.Chapters, chapter)
 	}
-	for _, section := range e.sections[2] {
-		err = e.buildSection(section, "postscript")
+	if len(e.endnotes) > 0 {
+		chapter, err := e.buildSection(e.endnotesSection(), "postscript")
 		if err != nil {
 			return err
 		}
+		if len(e.sections[2]) == 0 {
+			e.addLandmark("backmatter", "other.backmatter", "Back Matter", chapter.Path)
+		}
+		e.args.Chapters = append(e.args.Chapters, chapter)
+	}
+
+	if err := e.writePages(); err != nil {
+		return err
+	}
+
+	e.args.Depth = tocDepth(e.args.Chapters)
+	if e.args.Depth == 0 {
+		e.args.Depth = 1
+	}
+	if e.toc.Format != nil {
+		number := 0
+		e.formatTOC(e.args.Chapters, &number)
+	}
+	if err := e.placeTOC(); err != nil {
+		return err
 	}
 
 	// write text/toc.html
@@ -50,9 +249,13 @@ func (e *Book) Write(filename string) error {
 		return err
 	}
 	e.args.Files[len(e.args.Files)-1].Properties = "nav"
-	//if err := e.execTemplate("toc.ncx", "OEBPS/toc.ncx", mtNCX); err != nil {
-	//	return err
-	//}
+	if err := e.execTemplate("toc.ncx", "OEBPS/toc.ncx", mtNCX); err != nil {
+		return err
+	}
+
+	if e.reproducible {
+		e.sortFiles()
+	}
 
 	// write book.opf
 	if err := e.execTemplate("content.opf", "OEBPS/content.opf", mtOPF); err != nil {
@@ -66,26 +269,60 @@ func (e *Book) Write(filename string) error {
 			"Close zip file",
 		)
 	}
-	f, err := os.Create("temp.epub")
+	return nil
+}
+
+// finalize writes a built book to w as a finished epub.
+func finalize(w io.Writer, staged *bytes.Buffer, modified time.Time) error {
+	zr, err := zip.NewReader(bytes.NewReader(staged.Bytes()), int64(staged.Len()))
+	if err != nil {
+		return errors.Wrap(err, "Read staged epub")
+	}
+	f, err := newFinalizer(zr, modified)
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(f, e.buf)
+	return f.Write(w)
+}
+
+// writePolished finalizes a built book into a temporary file, runs
+// Calibre's ebook-polish on it, and copies the result to w.
+func writePolished(w io.Writer, staged *bytes.Buffer, modified time.Time) error {
+	if _, err := exec.LookPath("ebook-polish"); err != nil {
+		return errors.Wrap(err, "Calibre polish enabled")
+	}
+	dir, err := os.MkdirTemp("", "epub-polish-")
 	if err != nil {
 		return err
 	}
-	err = f.Close()
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in.epub")
+	out := filepath.Join(dir, "out.epub")
+	f, err := os.Create(in)
 	if err != nil {
 		return err
 	}
-	fmt.Println("Finalizing: ", filename)
-	cmd := exec.Command("ebook-polish", "-i", "-u", "temp.epub", filename)
+	if err := finalize(f, staged, modified); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("ebook-polish", "-i", "-u", in, out)
 	cmd.Stderr = os.Stderr
-	err = cmd.Run()
-	if err != nil {
+	if err := cmd.Run(); err != nil {
 		return errors.Wrap(err, "epub-polish error")
 	}
-	return os.Remove("temp.epub")
+	polished, err := os.Open(out)
+	if err != nil {
+		return err
+	}
+	defer polished.Close()
+	_, err = io.Copy(w, polished)
+	return err
 }
 
 func (e *Book) execTemplate(filename, zipName, mediaType string) error {
@@ -116,63 +353,173 @@ func (e *Book) execTemplate(filename, zipName, mediaType string) error {
 	return nil
 }
 
+// bookLandmark is an entry in the landmarks nav and the epub2 guide.
+type bookLandmark struct {
+	Type      string
+	GuideType string
+	Title     string
+	Path      string
+}
+
+func (e *Book) addLandmark(epubType, guideType, title, path string) {
+	e.args.Landmarks = append(e.args.Landmarks, bookLandmark{
+		Type:      epubType,
+		GuideType: guideType,
+		Title:     title,
+		Path:      path,
+	})
+}
+
+// structureTypes are the section types that are also terms of the
+// EPUB structural semantics vocabulary.
+var structureTypes = map[string]bool{
+	"chapter":        true,
+	"part":           true,
+	"titlepage":      true,
+	"copyright-page": true,
+	"dedication":     true,
+	"epigraph":       true,
+	"endnotes":       true,
+}
+
+// sectionEpubType returns the epub:type of a section: the matter
+// it belongs to, followed by its own type, like "bodymatter part".
+func sectionEpubType(priority, sectionType string) string {
+	matter := map[string]string{
+		"introduction": "frontmatter",
+		"chapter":      "bodymatter",
+		"postscript":   "backmatter",
+	}[priority]
+	if sectionType == "" && priority == "chapter" {
+		sectionType = "chapter"
+	}
+	// other section types are only used as classes
+	if !structureTypes[sectionType] {
+		return matter
+	}
+	return matter + " " + sectionType
+}
+
 type chapterArgs struct {
-	BookTitle  string
-	Title      string
-	Stylesheet string
-	ID         string
-	Content    string
-	Header     bool
+	Book               *bookArgs
+	BookTitle          string
+	Title              string
+	Label              string
+	Subtitle           string
+	Language           string
+	Direction          string
+	Stylesheet         string
+	VerticalStylesheet string
+	ID                 string
+	EpubType           string
+	Page               any
+	Content            string
+	Header             bool
 }
 
-func (e *Book) buildSection(section epubSection, sectionType string) error {
+func (e *Book) buildSection(section epubSection, sectionType string) (bookChapter, error) {
 	chap := chapterArgs{
-		BookTitle:  e.args.Title,
-		Title:      section.title,
-		Stylesheet: e.args.Stylesheet,
+		Book:               e.args,
+		BookTitle:          e.args.Title,
+		Title:              section.title,
+		Label:              section.label,
+		Subtitle:           section.subtitle,
+		Language:           e.args.Language,
+		Direction:          e.args.Direction,
+		Stylesheet:         e.args.Stylesheet,
+		VerticalStylesheet: e.args.VerticalStylesheet,
+		EpubType:           sectionEpubType(sectionType, section.sectionType),
+		Page:               section.page,
 	}
+	e.sectionCount++
 	name := fmt.Sprintf(
 		"chapter%03d-%s.xhtml",
-		len(e.args.Chapters)+1,
+		e.sectionCount,
 		"%d",
 	)
-	tt, err := CompileTemplate("chapter.xhtml")
+	if section.sectionType != "" {
+		sectionType = section.sectionType
+	}
+	template := "chapter.xhtml"
+	if section.template != "" {
+		template = section.template
+	}
+	tt, err := CompileTemplate(template)
 	if err != nil {
-		return err
+		return bookChapter{}, err
 	}
-	e.args.Chapters = append(
-		e.args.Chapters,
-		bookChapter{
-			NavPoint: fmt.Sprintf("navPoint%d", len(e.args.Chapters)+2),
-			ID:       fmt.Sprint(len(e.args.Chapters) + 1),
-			Title:    section.title,
-			Path:     "OEBPS/text/" + fmt.Sprintf(name, 0),
-			Type:     sectionType,
-		},
-	)
-
-	for i, part := range section.parts {
-		chap.ID = fmt.Sprintf(name, i)
-		chap.Content = part
+	chapter := bookChapter{
+		NavPoint: e.nextNavPoint(),
+		ID:       fmt.Sprint(e.sectionCount),
+		Title:    section.title,
+		Label:    section.label,
+		Subtitle: section.subtitle,
+		Path:     "OEBPS/text/" + fmt.Sprintf(name, 0),
+		Type:     sectionType,
+	}
+	names := make([]string, len(section.parts))
+	for i := range names {
+		names[i] = fmt.Sprintf(name, i)
+	}
+	parts := e.placeNotes(section.parts, names, section.title)
+	for i, part := range parts {
+		chap.ID = names[i]
+		chap.Content = e.tocHeadings(part, chap.ID, &chapter)
+		chap.Content = e.describeImages(chap.Content)
+		if e.args.WritingMode == string(Vertical) {
+			chap.Content = tateChuYoko(chap.Content)
+		}
 		chap.Header = i == 0
+		page := &bookPage{
+			args:     chap,
+			template: tt,
+			slug:     slugify(section.title),
+		}
+		if i < len(section.sources) {
+			page.sources = section.sources[i]
+		}
+		e.pages = append(e.pages, page)
+	}
+	return chapter, nil
+}
 
+// bookPage is a page of a section waiting to be written once the
+// links between pages are resolved.
+type bookPage struct {
+	args     chapterArgs
+	template *template.Template
+	// slug is the slug of the section title
+	slug string
+	// sources are the paths of the opened documents the page was
+	// read from
+	sources []string
+}
+
+// writePages resolves the links between pages and writes them to
+// the staging archive in order.
+func (e *Book) writePages() error {
+	if err := resolveLinks(e.pages); err != nil {
+		return err
+	}
+	for _, page := range e.pages {
+		name := page.args.ID
 		e.args.Files = append(e.args.Files, bookFile{
-			ID:        chap.ID,
-			Path:      "OEBPS/text/" + chap.ID,
+			ID:        name,
+			Path:      "OEBPS/text/" + name,
 			MediaType: mtXHTML,
 		})
-		e.args.Sections = append(e.args.Sections, bookSection{Ref: chap.ID})
+		e.args.Sections = append(e.args.Sections, bookSection{Ref: name})
 		buf, _ := e.file.CreateHeader(&zip.FileHeader{
-			Name:   "OEBPS/text/" + chap.ID,
+			Name:   "OEBPS/text/" + name,
 			Method: zip.Store,
 		})
-		err := tt.Execute(buf, chap)
+		err := page.template.Execute(buf, page.args)
 		if err != nil {
 			return errors.Wrap(
 				err,
 				fmt.Sprintf(
 					"Chapter Exec Error (%s): ",
-					chap.ID,
+					name,
 				),
 			)
 		}
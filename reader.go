@@ -0,0 +1,849 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// Open reads the epub at filename into a new Book so that it can
+// be changed and written back out with Write.
+func Open(filename string) (*Book, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, &FileRetrievalError{Source: filename, Err: err}
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, &FileRetrievalError{Source: filename, Err: err}
+	}
+	return OpenReader(f, info.Size())
+}
+
+// OpenReader reads an epub of the given size from r into a new Book.
+// The metadata, images, assets, and stylesheet are copied over, and
+// every document in the spine becomes part of an introduction,
+// chapter, or postscript section. Documents that aren't listed in
+// the table of contents are treated as page breaks of the section
+// before them.
+func OpenReader(r io.ReaderAt, size int64) (*Book, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "Read epub")
+	}
+	a, err := openArchive(zr)
+	if err != nil {
+		return nil, err
+	}
+	br := &bookReader{
+		epubArchive: a,
+		paths:       make(map[string]string),
+		toc:         make(map[string]tocEntry),
+	}
+	return br.load()
+}
+
+// bookReader rebuilds a Book from an opened archive.
+type bookReader struct {
+	*epubArchive
+	book *Book
+
+	// paths maps archive paths to their new location, relative
+	// to the text folder
+	paths map[string]string
+	toc   map[string]tocEntry
+	// tocDepth is how many levels of headings the table of
+	// contents lists, counting the chapters
+	tocDepth int
+}
+
+type tocEntry struct {
+	title    string
+	priority int
+	cover    bool
+	part     bool
+}
+
+type ncxNavPoint struct {
+	Label   string `xml:"navLabel>text"`
+	Content struct {
+		Src string `xml:"src,attr"`
+	} `xml:"content"`
+	Children []ncxNavPoint `xml:"navPoint"`
+}
+type ncxDocument struct {
+	NavPoints []ncxNavPoint `xml:"navMap>navPoint"`
+}
+
+func (br *bookReader) load() (*Book, error) {
+	md := br.pkg.Metadata
+	e := NewBook(firstValue(md.Titles))
+	br.book = e
+	br.readCreators(md.Creators, RoleAuthor, e.AddCreator)
+	br.readCreators(md.Contributors, "", e.AddContributor)
+	br.readSeries()
+	e.SetDescription(firstValue(md.Descriptions))
+	e.SetPublisher(firstValue(md.Publishers))
+	e.SetReleaseDate(firstValue(md.Dates))
+	e.SetRights(firstValue(md.Rights))
+	for _, s := range md.Subjects {
+		authority, term := br.refinement(s.ID, "authority"), br.refinement(s.ID, "term")
+		e.AddSubject(strings.TrimSpace(s.Value), authority, term)
+	}
+	if lang := firstValue(md.Languages); lang != "" {
+		// epub2 books often use locales, like en_US
+		lang = strings.ReplaceAll(lang, "_", "-")
+		if err := e.SetLanguage(lang); err != nil {
+			// kept as it is, Validate warns about it
+			e.args.Language = lang
+		}
+	}
+	if ppd := strings.TrimSpace(br.pkg.Spine.PageProgression); ppd != "" {
+		if err := e.SetPageProgression(PageProgression(strings.ToLower(ppd))); err != nil {
+			// unknown directions are left to the reading system
+			e.SetPageProgression(DefaultProgression)
+		}
+	}
+	br.readIdentifiers()
+	br.readAccessibility()
+
+	if err := br.readResources(); err != nil {
+		return nil, err
+	}
+	if err := br.readTOC(); err != nil {
+		return nil, err
+	}
+	if br.tocDepth > 1 {
+		e.SetTOCDepth(br.tocDepth)
+	}
+	if err := br.readSpine(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// readCreators adds creators or contributors with the role and
+// file-as from either refining metas or epub2 attributes.
+func (br *bookReader) readCreators(elements []opfElement, defaultRole string, add func(name, role, fileAs string)) {
+	for _, el := range elements {
+		role, fileAs := el.Role, el.FileAs
+		for _, m := range br.pkg.Metadata.Metas {
+			if el.ID == "" || m.Refines != "#"+el.ID {
+				continue
+			}
+			switch m.Property {
+			case "role":
+				role = strings.TrimSpace(m.Value)
+			case "file-as":
+				fileAs = strings.TrimSpace(m.Value)
+			}
+		}
+		if role == "" {
+			role = defaultRole
+		}
+		add(strings.TrimSpace(el.Value), role, fileAs)
+	}
+}
+
+// readIdentifiers sets the unique identifier and adds the others
+// as alternate identifiers.
+func (br *bookReader) readIdentifiers() {
+	ids := br.pkg.Metadata.Identifiers
+	for _, id := range ids {
+		value := strings.TrimSpace(id.Value)
+		if id.ID == br.pkg.UniqueIdentifier || len(ids) == 1 {
+			br.book.SetIdentifier(value)
+			continue
+		}
+		scheme := ""
+		if parts := strings.SplitN(value, ":", 3); len(parts) == 3 && parts[0] == "urn" {
+			scheme, value = parts[1], parts[2]
+		}
+		for _, m := range br.pkg.Metadata.Metas {
+			if scheme == "" && id.ID != "" && m.Refines == "#"+id.ID && m.Property == "identifier-type" {
+				scheme = identifierScheme(strings.TrimSpace(m.Value))
+			}
+		}
+		if scheme == "" {
+			scheme = "other"
+		}
+		// identifiers that don't validate are dropped
+		br.book.AddAlternateIdentifier(scheme, value)
+	}
+}
+
+// identifierScheme maps an ONIX identifier type back to a scheme.
+func identifierScheme(onix string) string {
+	if onix == onixISBN10 {
+		return "isbn"
+	}
+	for scheme, t := range identifierTypes {
+		if t.onix == onix && onix != "" {
+			return scheme
+		}
+	}
+	return onix
+}
+
+// refinement returns the value of the meta with property that
+// refines the element with id.
+func (br *bookReader) refinement(id, property string) string {
+	for _, m := range br.pkg.Metadata.Metas {
+		if id != "" && m.Refines == "#"+id && m.Property == property {
+			return strings.TrimSpace(m.Value)
+		}
+	}
+	return ""
+}
+
+// readAccessibility reads the accessibility metas. The access
+// modes are left for the build to detect again.
+func (br *bookReader) readAccessibility() {
+	a := Accessibility{}
+	found := false
+	for _, m := range br.pkg.Metadata.Metas {
+		if m.Refines != "" {
+			continue
+		}
+		value := strings.TrimSpace(m.Value)
+		switch m.Property {
+		case "schema:accessModeSufficient":
+			a.AccessModesSufficient = append(a.AccessModesSufficient, value)
+		case "schema:accessibilityFeature":
+			a.Features = append(a.Features, value)
+		case "schema:accessibilityHazard":
+			a.Hazards = append(a.Hazards, value)
+		case "schema:accessibilitySummary":
+			a.Summary = value
+		case "dcterms:conformsTo":
+			a.ConformsTo = value
+		case "a11y:certifiedBy":
+			a.CertifiedBy = value
+		case "schema:accessMode":
+		default:
+			continue
+		}
+		found = true
+	}
+	if found {
+		br.book.SetAccessibility(a)
+	}
+}
+
+// readSeries reads the series from belongs-to-collection, falling
+// back to the calibre:series metas.
+func (br *bookReader) readSeries() {
+	name, index := "", ""
+	for _, m := range br.pkg.Metadata.Metas {
+		switch {
+		case m.Property == "belongs-to-collection" && name == "":
+			name = strings.TrimSpace(m.Value)
+			for _, r := range br.pkg.Metadata.Metas {
+				if m.ID != "" && r.Refines == "#"+m.ID && r.Property == "group-position" {
+					index = strings.TrimSpace(r.Value)
+				}
+			}
+		case m.Name == "calibre:series" && name == "":
+			name = m.Content
+		case m.Name == "calibre:series_index" && index == "":
+			index = m.Content
+		}
+	}
+	position, _ := strconv.ParseFloat(index, 64)
+	br.book.SetSeries(name, position)
+}
+
+func firstValue(elements []opfElement) string {
+	if len(elements) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(elements[0].Value)
+}
+
+func hasProperty(properties, property string) bool {
+	for _, p := range strings.Fields(properties) {
+		if p == property {
+			return true
+		}
+	}
+	return false
+}
+
+// readResources copies the images, assets, and stylesheets, and
+// the fallbacks between them.
+func (br *bookReader) readResources() error {
+	e := br.book
+	coverID := br.coverID()
+	css := []opfItem{}
+	// ids maps manifest ids to the ids of the copied files
+	ids := make(map[string]string)
+	for _, item := range br.pkg.Manifest {
+		p := br.itemPath(item)
+		switch {
+		case item.MediaType == mtXHTML, item.MediaType == mtNCX:
+			continue
+		case item.MediaType == "text/css":
+			css = append(css, item)
+			continue
+		}
+
+		b, err := br.read(p)
+		if err != nil {
+			return err
+		}
+		switch {
+		case item.ID == coverID:
+			ext := path.Ext(p)
+			if err := e.addImage(bytes.NewReader(b), "cover"+ext, item.MediaType); err != nil {
+				return err
+			}
+			e.markCover("cover" + ext)
+			br.paths[p] = e.args.CoverImage
+		case strings.HasPrefix(item.MediaType, "image/"):
+			name := imageName(item.Href)
+			if err := e.addImage(bytes.NewReader(b), name, item.MediaType); err != nil {
+				return err
+			}
+			br.paths[p] = e.imageLookup[name]
+		default:
+			name := br.assetName(p)
+			if err := e.addAsset(bytes.NewReader(b), name, item.MediaType); err != nil {
+				return err
+			}
+			br.paths[p] = e.assetLookup[name]
+		}
+		ids[item.ID] = e.args.Files[len(e.args.Files)-1].ID
+	}
+	for _, item := range br.pkg.Manifest {
+		if ids[item.ID] == "" || ids[item.Fallback] == "" {
+			continue
+		}
+		for i := range e.args.Files {
+			if e.args.Files[i].ID == ids[item.ID] {
+				e.args.Files[i].Fallback = ids[item.Fallback]
+			}
+		}
+	}
+	return br.readCSS(css)
+}
+
+// assetName returns the name an asset is added under, its path
+// relative to the package document, so assets in different folders
+// keep apart. Assets written by this package keep their names.
+func (br *bookReader) assetName(p string) string {
+	if dir := path.Dir(br.opfPath); dir != "." {
+		p = strings.TrimPrefix(p, dir+"/")
+	}
+	return strings.TrimPrefix(p, "assets/")
+}
+
+// imageName undoes the naming done by AddImage, so books written
+// by this package keep their image lookup names.
+func imageName(href string) string {
+	name := strings.TrimPrefix(href, "images/")
+	return strings.TrimPrefix(name, "img_")
+}
+
+// readCSS merges every stylesheet except the built in one into the
+// book's stylesheet, pointing url() references at their new paths.
+func (br *bookReader) readCSS(items []opfItem) error {
+	def, _ := RetrieveTemplate("default.css")
+	merged := &bytes.Buffer{}
+	for _, item := range items {
+		p := br.itemPath(item)
+		b, err := br.read(p)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(b, def) {
+			continue
+		}
+		b = cssURLRegex.ReplaceAllFunc(b, func(m []byte) []byte {
+			ref := string(cssURLRegex.FindSubmatch(m)[1])
+			if np, ok := br.paths[resolveRef(p, ref)]; ok {
+				return []byte(`url("` + strings.TrimPrefix(np, "../") + `")`)
+			}
+			return m
+		})
+		merged.Write(b)
+		merged.WriteString("\n")
+	}
+	if merged.Len() == 0 {
+		return nil
+	}
+	return br.book.setCSS(merged)
+}
+
+// readTOC collects chapter titles from nav.xhtml, or from toc.ncx
+// for epub2 books and navs without a toc.
+func (br *bookReader) readTOC() error {
+	for _, item := range br.pkg.Manifest {
+		if !hasProperty(item.Properties, "nav") {
+			continue
+		}
+		p := br.itemPath(item)
+		b, err := br.read(p)
+		if err != nil {
+			return err
+		}
+		doc, err := html.Parse(bytes.NewReader(b))
+		if err != nil {
+			return errors.Wrap(err, "Parse "+p)
+		}
+		nav := findElement(doc, func(n *html.Node) bool {
+			return n.Data == "nav" && strings.Contains(attr(n, "epub:type"), "toc")
+		})
+		if nav != nil {
+			br.readNavList(nav, p, "", 1)
+			return nil
+		}
+	}
+
+	item, ok := br.item(br.pkg.Spine.Toc)
+	if !ok {
+		return nil
+	}
+	p := br.itemPath(item)
+	b, err := br.read(p)
+	if err != nil {
+		return err
+	}
+	ncx := ncxDocument{}
+	if err := xml.Unmarshal(b, &ncx); err != nil {
+		return errors.Wrap(err, "Parse "+p)
+	}
+	var walk func(points []ncxNavPoint, parent string, parentLevel int)
+	walk = func(points []ncxNavPoint, parent string, parentLevel int) {
+		for _, np := range points {
+			target := resolveRef(p, np.Content.Src)
+			level := br.tocLevel(target, parent, parentLevel)
+			if _, ok := br.toc[target]; !ok && target != "" && level == 1 {
+				br.toc[target] = tocEntry{
+					title:    strings.TrimSpace(np.Label),
+					priority: 1,
+				}
+			}
+			walk(np.Children, target, level)
+		}
+	}
+	walk(ncx.NavPoints, "", 1)
+	return nil
+}
+
+// readNavList adds the entries of a nav list, found under n, that
+// are nested under the entry for parent at parentLevel.
+func (br *bookReader) readNavList(n *html.Node, p, parent string, parentLevel int) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if c.Data != "li" {
+			br.readNavList(c, p, parent, parentLevel)
+			continue
+		}
+		a := navLink(c)
+		if a == nil {
+			br.readNavList(c, p, parent, parentLevel)
+			continue
+		}
+		target := resolveRef(p, attr(a, "href"))
+		level := br.tocLevel(target, parent, parentLevel)
+		if _, ok := br.toc[target]; !ok && target != "" && level == 1 {
+			br.toc[target] = tocEntry{
+				title:    textContent(a),
+				priority: sectionPriority(attr(c, "class")),
+				cover:    hasClass(c, "cover"),
+				part:     hasClass(c, "part"),
+			}
+		}
+		br.readNavList(c, p, target, level)
+	}
+}
+
+// tocLevel returns the level of a table of contents entry. Entries
+// pointing into the document of the entry they're nested under are
+// headings inside that chapter, a level below it. Every other entry
+// is a section of its own at level 1.
+func (br *bookReader) tocLevel(target, parent string, parentLevel int) int {
+	if target == "" || target != parent {
+		return 1
+	}
+	level := parentLevel + 1
+	if level > br.tocDepth {
+		br.tocDepth = level
+	}
+	return level
+}
+
+// navLink returns the link of a nav list item, leaving out the
+// links of the items nested in it.
+func navLink(li *html.Node) *html.Node {
+	for c := li.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data == "ol" {
+			continue
+		}
+		if a := findElement(c, func(n *html.Node) bool { return n.Data == "a" }); a != nil {
+			return a
+		}
+	}
+	return nil
+}
+
+// generatedPages maps the epub:type of the pages made from the
+// book's metadata to their templates.
+var generatedPages = map[string]string{
+	"titlepage":      "titlepage.xhtml",
+	"copyright-page": "copyright.xhtml",
+	"dedication":     "dedication.xhtml",
+	"epigraph":       "epigraph.xhtml",
+}
+
+// sectionPriority maps a class or epub:type value to the
+// introduction, chapter, or postscript priority.
+func sectionPriority(types string) int {
+	for _, t := range strings.Fields(types) {
+		switch t {
+		case "introduction", "frontmatter":
+			return 0
+		case "postscript", "backmatter":
+			return 2
+		}
+	}
+	return 1
+}
+
+// hasMatter reports whether types names the front, body, or back
+// matter.
+func hasMatter(types string) bool {
+	for _, t := range strings.Fields(types) {
+		switch t {
+		case "frontmatter", "bodymatter", "backmatter":
+			return true
+		}
+	}
+	return false
+}
+
+// readSpine turns the spine documents into sections.
+func (br *bookReader) readSpine() error {
+	coverPath := ""
+	if item, ok := br.item(br.coverID()); ok {
+		coverPath = br.itemPath(item)
+	}
+
+	// links to other documents are resolved to the pages they
+	// become when the book is built, and links to the documents
+	// that are made again point at the new ones
+	for _, ref := range br.pkg.Spine.Itemrefs {
+		if item, ok := br.item(ref.IDRef); ok && item.MediaType == mtXHTML {
+			br.paths[br.itemPath(item)] = "#source:" + br.itemPath(item)
+		}
+	}
+	for _, item := range br.pkg.Manifest {
+		if hasProperty(item.Properties, "nav") {
+			br.paths[br.itemPath(item)] = "nav.xhtml"
+		}
+	}
+	replaced := make(map[string]string)
+
+	var current *epubSection
+	priority := 0
+	// the table of contents goes where the first one in the
+	// reading order is, or is hidden when there isn't one
+	placement, placed := TOCHidden, false
+	placeTOC := func(ref opfItemref) {
+		if placed || ref.Linear == "no" {
+			return
+		}
+		placed = true
+		placement = TOCAfterCover
+		if current != nil {
+			placement = TOCAfterFrontMatter
+		}
+	}
+	// pending are empty documents, which link to the next page
+	pending := []string{}
+	flush := func() {
+		if current == nil {
+			return
+		}
+		// sections need at least one page, even when empty
+		if len(current.parts) == 0 {
+			current.parts = []string{""}
+			current.sources = [][]string{nil}
+		}
+		last := len(current.sources) - 1
+		current.sources[last] = append(current.sources[last], pending...)
+		pending = []string{}
+		br.book.sections[priority] = append(br.book.sections[priority], *current)
+	}
+	for i, ref := range br.pkg.Spine.Itemrefs {
+		item, ok := br.item(ref.IDRef)
+		if !ok || item.MediaType != mtXHTML {
+			continue
+		}
+		if hasProperty(item.Properties, "nav") {
+			placeTOC(ref)
+			continue
+		}
+		p := br.itemPath(item)
+		b, err := br.read(p)
+		if err != nil {
+			return err
+		}
+		entry, listed := br.toc[p]
+		doc, err := br.readDocument(p, b, entry.title)
+		if err != nil {
+			return err
+		}
+		// the first document is the cover page if it shows the cover
+		if (i == 0 && coverPath != "" && doc.references(coverPath)) || entry.cover {
+			replaced[p] = "cover.xhtml"
+			continue
+		}
+		// a printed style Contents page is made again from the toc
+		if hasProperty(doc.epubType, "toc") {
+			br.book.toc.ContentsPage = true
+			replaced[p] = "contents.xhtml"
+			placeTOC(ref)
+			continue
+		}
+		if listed || current == nil {
+			flush()
+			title := doc.title
+			priority = sectionPriority(doc.epubType)
+			if listed {
+				// the toc title may have been formatted, so the
+				// chapter's own heading wins
+				if !doc.heading {
+					title = entry.title
+				}
+				// the matter in epub:type wins over the nav class
+				if !hasMatter(doc.epubType) {
+					priority = entry.priority
+				}
+			}
+			current = &epubSection{title: title}
+			for t, template := range generatedPages {
+				if hasProperty(doc.epubType, t) {
+					current.template = template
+					current.sectionType = t
+					current.label = doc.label
+					current.subtitle = doc.subtitle
+				}
+			}
+			if current.sectionType == "titlepage" {
+				// the title page is filled in from the metadata
+				doc.content = ""
+			}
+			if entry.part || hasProperty(doc.epubType, "part") {
+				current.template = "part.xhtml"
+				current.sectionType = "part"
+				current.label = doc.label
+				current.subtitle = doc.subtitle
+			}
+		}
+		pending = append(pending, p)
+		if doc.content != "" {
+			current.parts = append(current.parts, doc.content)
+			current.sources = append(current.sources, pending)
+			pending = []string{}
+		}
+	}
+	flush()
+	br.book.toc.Placement = placement
+	br.replaceLinks(replaced)
+	return nil
+}
+
+// replaceLinks points links to documents that were made again,
+// like the cover page, at the new ones.
+func (br *bookReader) replaceLinks(replaced map[string]string) {
+	for p, target := range replaced {
+		link := regexp.MustCompile(`href="#source:` + regexp.QuoteMeta(html.EscapeString(p)) + `(#[^"]*)?"`)
+		for i := range br.book.sections {
+			for j := range br.book.sections[i] {
+				parts := br.book.sections[i][j].parts
+				for k := range parts {
+					parts[k] = link.ReplaceAllString(parts[k], `href="`+target+`"`)
+				}
+			}
+		}
+	}
+}
+
+type readDocument struct {
+	title string
+	// heading is set when title is from the chapter's own heading
+	heading  bool
+	label    string
+	subtitle string
+	epubType string
+	content  string
+	refs     []string
+}
+
+func (d readDocument) references(p string) bool {
+	for _, ref := range d.refs {
+		if ref == p {
+			return true
+		}
+	}
+	return false
+}
+
+// readDocument pulls the body content out of a spine document.
+// Chapters written by this package only keep what was inside the
+// cahaba--main div, without the generated title. Other chapters
+// lose their first heading when it matches the toc title, since
+// the generated title takes its place.
+func (br *bookReader) readDocument(p string, b []byte, tocTitle string) (readDocument, error) {
+	d := readDocument{}
+	doc, err := html.Parse(bytes.NewReader(b))
+	if err != nil {
+		return d, errors.Wrap(err, "Parse "+p)
+	}
+	if t := findElement(doc, func(n *html.Node) bool { return n.Data == "title" }); t != nil {
+		d.title = textContent(t)
+	}
+	body := findElement(doc, func(n *html.Node) bool { return n.Data == "body" })
+	if body == nil {
+		return d, nil
+	}
+	walkElements(body, func(n *html.Node) {
+		if d.epubType == "" {
+			d.epubType = attr(n, "epub:type")
+		}
+	})
+
+	container := body
+	if main := findElement(body, func(n *html.Node) bool { return hasClass(n, "cahaba--main") }); main != nil {
+		container = main
+		if h := findElement(main, func(n *html.Node) bool { return n.Data == "h1" && hasClass(n, "cahaba--title") }); h != nil {
+			d.title = textContent(h)
+			d.heading = true
+			h.Parent.RemoveChild(h)
+		}
+		if p := findElement(main, func(n *html.Node) bool { return hasClass(n, "cahaba--part-label") }); p != nil {
+			d.label = textContent(p)
+			p.Parent.RemoveChild(p)
+		}
+		if p := findElement(main, func(n *html.Node) bool {
+			return hasClass(n, "cahaba--part-subtitle") || hasClass(n, "cahaba--epigraph-attribution")
+		}); p != nil {
+			d.subtitle = textContent(p)
+			p.Parent.RemoveChild(p)
+		}
+		if q := findElement(main, func(n *html.Node) bool { return hasClass(n, "cahaba--epigraph-quote") }); q != nil {
+			container = q
+		}
+	} else if tocTitle != "" {
+		h := findElement(body, func(n *html.Node) bool { return n.Data == "h1" || n.Data == "h2" })
+		if h != nil && strings.EqualFold(textContent(h), tocTitle) {
+			d.title = textContent(h)
+			d.heading = true
+			h.Parent.RemoveChild(h)
+		}
+	}
+	if p := findElement(body, func(n *html.Node) bool { return hasClass(n, "cahaba--edition") }); p != nil {
+		d.label = textContent(p)
+	}
+
+	walkElements(container, func(n *html.Node) {
+		for i, a := range n.Attr {
+			if a.Key != "src" && a.Key != "href" && a.Key != "poster" {
+				continue
+			}
+			target := resolveRef(p, a.Val)
+			if target == "" {
+				continue
+			}
+			d.refs = append(d.refs, target)
+			if np, ok := br.paths[target]; ok {
+				if j := strings.Index(a.Val, "#"); j >= 0 {
+					np += a.Val[j:]
+				}
+				n.Attr[i].Val = np
+			}
+		}
+	})
+
+	buf := &bytes.Buffer{}
+	for c := container.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(buf, c); err != nil {
+			return d, errors.Wrap(err, "Render "+p)
+		}
+	}
+	d.content = strings.TrimSpace(buf.String())
+	return d, nil
+}
+
+func findElement(n *html.Node, match func(*html.Node) bool) *html.Node {
+	if n.Type == html.ElementNode && match(n) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func walkElements(n *html.Node, fn func(*html.Node)) {
+	if n.Type == html.ElementNode {
+		fn(n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkElements(c, fn)
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		name := a.Key
+		if a.Namespace != "" {
+			name = a.Namespace + ":" + a.Key
+		}
+		if name == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, c := range strings.Fields(attr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+var spaceRegex = regexp.MustCompile(`\s+`)
+
+func textContent(n *html.Node) string {
+	buf := &strings.Builder{}
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.TrimSpace(spaceRegex.ReplaceAllString(buf.String(), " "))
+}
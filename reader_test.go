@@ -0,0 +1,182 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// openTest opens the epub made of files.
+func openTest(t *testing.T, files map[string]string) *Book {
+	t.Helper()
+	b := zipBook(t, files)
+	e, err := OpenReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return e
+}
+
+// readEpub opens a built book as a zip.
+func readEpub(t *testing.T, b []byte) *zip.Reader {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestOpenThirdParty(t *testing.T) {
+	out := buildValid(t, openTest(t, testBook()))
+	docs := documents(t, out)
+
+	var linked bool
+	for name, doc := range docs {
+		if strings.Contains(doc, "ch02.xhtml") {
+			t.Errorf("%s still links to the original document", name)
+		}
+		if strings.Contains(doc, "#n1\"") {
+			linked = true
+		}
+		for _, title := range []string{"First", "Second"} {
+			if n := strings.Count(doc, ">"+title+"</h1>"); n > 1 {
+				t.Errorf("%s has %d %q headings", name, n, title)
+			}
+		}
+	}
+	if !linked {
+		t.Error("Link to ch02.xhtml#n1 was dropped")
+	}
+}
+
+func TestOpenMetadataValues(t *testing.T) {
+	tests := []struct {
+		lang, ppd         string
+		wantLang, wantPPD string
+	}{
+		{"en_US", "rtl", "en-US", "rtl"},
+		{"x-pig-latin", "LTR", "x-pig-latin", "ltr"},
+		{"english", "sideways", "english", "default"},
+	}
+	for _, tt := range tests {
+		files := testBook()
+		opf := strings.Replace(testOPF, "<dc:language>en</dc:language>", "<dc:language>"+tt.lang+"</dc:language>", 1)
+		files["OEBPS/content.opf"] = strings.Replace(opf, "<spine>", `<spine page-progression-direction="`+tt.ppd+`">`, 1)
+		e := openTest(t, files)
+		if e.Language() != tt.wantLang || string(e.PageProgression()) != tt.wantPPD {
+			t.Errorf("Opened %q and %q as %q and %q, want %q and %q",
+				tt.lang, tt.ppd, e.Language(), e.PageProgression(), tt.wantLang, tt.wantPPD)
+		}
+	}
+}
+
+func TestOpenNCX(t *testing.T) {
+	files := testBook()
+	files["OEBPS/content.opf"] = strings.NewReplacer(
+		`<item id="ch01"`, `<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="ch01"`,
+		"<spine>", `<spine toc="ncx">`,
+	).Replace(testOPF)
+	// the nav only has landmarks, so the titles come from the ncx
+	files["OEBPS/nav.xhtml"] = strings.Replace(files["OEBPS/nav.xhtml"], `epub:type="toc"`, `epub:type="landmarks"`, 1)
+	files["OEBPS/toc.ncx"] = `<?xml version="1.0" encoding="utf-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <navMap>
+    <navPoint id="np1"><navLabel><text>Opening</text></navLabel><content src="ch01.xhtml"/></navPoint>
+    <navPoint id="np2"><navLabel><text>Closing</text></navLabel><content src="ch02.xhtml"/>
+      <navPoint id="np3"><navLabel><text>The Note</text></navLabel><content src="ch02.xhtml#n1"/></navPoint>
+    </navPoint>
+  </navMap>
+</ncx>`
+	files["OEBPS/ch01.xhtml"] = strings.Replace(files["OEBPS/ch01.xhtml"], "<h1>First</h1>", "", 1)
+	files["OEBPS/ch02.xhtml"] = strings.Replace(files["OEBPS/ch02.xhtml"], "<h1>Second</h1>", "", 1)
+
+	e := openTest(t, files)
+	if e.tocDepth != 2 {
+		t.Errorf("Opened TOC depth %d, want 2", e.tocDepth)
+	}
+	nav := documents(t, buildValid(t, e))["OEBPS/text/nav.xhtml"]
+	for _, title := range []string{"Opening", "Closing"} {
+		if !strings.Contains(nav, ">"+title+"</a>") {
+			t.Errorf("nav.xhtml is missing %q:\n%s", title, nav)
+		}
+	}
+}
+
+func TestOpenAssetPaths(t *testing.T) {
+	files := testBook()
+	files["OEBPS/content.opf"] = strings.Replace(testOPF, `  </manifest>`, `    <item id="style" href="style.css" media-type="text/css"/>
+    <item id="serif" href="fonts/serif/regular.ttf" media-type="font/ttf"/>
+    <item id="sans" href="fonts/sans/regular.ttf" media-type="font/ttf"/>
+  </manifest>`, 1)
+	files["OEBPS/style.css"] = `@font-face { font-family: serif; src: url(fonts/serif/regular.ttf); }
+@font-face { font-family: sans; src: url(fonts/sans/regular.ttf); }`
+	files["OEBPS/ch01.xhtml"] = strings.Replace(files["OEBPS/ch01.xhtml"], "<head>",
+		`<head><link href="style.css" rel="stylesheet" type="text/css"/>`, 1)
+	files["OEBPS/fonts/serif/regular.ttf"] = "serif"
+	files["OEBPS/fonts/sans/regular.ttf"] = "sans"
+
+	e := openTest(t, files)
+	out := buildValid(t, e)
+	zr := readEpub(t, out)
+	for _, font := range []string{"serif", "sans"} {
+		name := "OEBPS/assets/fonts/" + font + "/regular.ttf"
+		if got := readZipFile(t, zr, name); got != font {
+			t.Errorf("%s has %q, want %q", name, got, font)
+		}
+	}
+	opf := documents(t, out)["OEBPS/content.opf"]
+	if strings.Count(opf, `id="regular.ttf"`) != 1 {
+		t.Errorf("Fonts don't have unique manifest ids:\n%s", opf)
+	}
+}
+
+func TestOpenFallback(t *testing.T) {
+	files := testBook()
+	files["OEBPS/content.opf"] = strings.Replace(testOPF, `  </manifest>`, `    <item id="model" href="model.glb" media-type="model/gltf-binary" fallback="still"/>
+    <item id="still" href="still.png" media-type="image/png"/>
+  </manifest>`, 1)
+	files["OEBPS/ch02.xhtml"] = strings.Replace(files["OEBPS/ch02.xhtml"], "The note.", `<img src="still.png" alt="A model"/><a href="model.glb">Model</a>`, 1)
+	files["OEBPS/model.glb"] = "model"
+	files["OEBPS/still.png"] = string(testPNGBytes(t))
+
+	opf := documents(t, buildValid(t, openTest(t, files)))["OEBPS/content.opf"]
+	if !strings.Contains(opf, `href="assets/model.glb" media-type="model/gltf-binary" fallback="img_still.png"`) {
+		t.Errorf("content.opf lost the fallback:\n%s", opf)
+	}
+}
+
+func TestOpenTOCPlacement(t *testing.T) {
+	tests := []struct {
+		opts  TOCOptions
+		depth int
+	}{
+		{TOCOptions{Placement: TOCAfterCover}, 1},
+		{TOCOptions{Placement: TOCAfterFrontMatter}, 2},
+		{TOCOptions{Placement: TOCHidden}, 3},
+		{TOCOptions{Placement: TOCAfterFrontMatter, ContentsPage: true}, 1},
+		{TOCOptions{Placement: TOCHidden, ContentsPage: true}, 2},
+	}
+	for _, tt := range tests {
+		e := NewBook("Test Book")
+		must(t, e.SetTOC(tt.opts))
+		e.SetTOCDepth(tt.depth)
+		must(t, e.AddTitlePage())
+		must(t, e.AddChapterMD("One", "Text.\n\n## Part A\n\nMore.\n\n### Detail\n\nEven more."))
+		must(t, e.AddChapterMD("Two", "Text."))
+		b := buildValid(t, e)
+
+		opened, err := OpenReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opened.toc.Placement != tt.opts.Placement || opened.toc.ContentsPage != tt.opts.ContentsPage {
+			t.Errorf("Opened %+v, want %+v", opened.toc, tt.opts)
+		}
+		if opened.tocDepth != tt.depth {
+			t.Errorf("Opened TOC depth %d, want %d", opened.tocDepth, tt.depth)
+		}
+	}
+}
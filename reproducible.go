@@ -0,0 +1,52 @@
+package epub
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// zipEpoch is the earliest time a zip header can hold.
+var zipEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SetReproducible makes building the same inputs produce a byte for
+// byte identical epub. The modified date and zip entry times come
+// from SetModifiedTime, then the SOURCE_DATE_EPOCH environment
+// variable, and finally fall back to 1980-01-01. The manifest is
+// sorted by path, and the identifier is derived from the title and
+// author unless one was set. Calibre polish doesn't produce
+// reproducible output.
+func (e *Book) SetReproducible(enabled bool) {
+	e.reproducible = enabled
+}
+
+// SetModifiedTime sets the dcterms:modified date and the time of
+// every zip entry, instead of the time the book is built.
+func (e *Book) SetModifiedTime(t time.Time) {
+	e.modified = t.UTC().Truncate(time.Second)
+}
+
+// modifiedTime returns the time the book is considered modified.
+func (e *Book) modifiedTime() time.Time {
+	if !e.modified.IsZero() {
+		return e.modified
+	}
+	if !e.reproducible {
+		return time.Now().UTC().Truncate(time.Second)
+	}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		if sec, err := strconv.ParseInt(epoch, 10, 64); err == nil {
+			return time.Unix(sec, 0).UTC()
+		}
+	}
+	return zipEpoch
+}
+
+// sortFiles puts the manifest in a stable order, so books whose
+// files were added concurrently still build the same way.
+func (e *Book) sortFiles() {
+	sort.SliceStable(e.args.Files, func(i, j int) bool {
+		return e.args.Files[i].Path < e.args.Files[j].Path
+	})
+}
@@ -0,0 +1,64 @@
+package epub
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reproducibleBook builds the same small book, adding its images in
+// the given order.
+func reproducibleBook(t *testing.T, images []string) []byte {
+	t.Helper()
+	e := NewBook("Test Book")
+	e.SetAuthor("Test Author")
+	e.SetReproducible(true)
+	for _, name := range images {
+		must(t, e.AddImage("testdata/gophercolor16x16.png", name))
+	}
+	must(t, e.AddChapterMD("One", "The first chapter."))
+	must(t, e.AddChapterMD("Two", "The second chapter."))
+	return buildValid(t, e)
+}
+
+func TestReproducible(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	a := reproducibleBook(t, []string{"a.png", "b.png"})
+	time.Sleep(1100 * time.Millisecond)
+	b := reproducibleBook(t, []string{"b.png", "a.png"})
+	if !bytes.Equal(a, b) {
+		t.Error("Books built from the same inputs differ")
+	}
+	for _, zf := range readEpub(t, a).File {
+		if !zf.Modified.Equal(zipEpoch) {
+			t.Errorf("%s was modified %v, want %v", zf.Name, zf.Modified, zipEpoch)
+		}
+	}
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	tests := []struct {
+		epoch    string
+		modified string
+		zip      time.Time
+	}{
+		{"1600000000", "2020-09-13T12:26:40Z", time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)},
+		// zip headers can't hold times before 1980
+		{"0", "1970-01-01T00:00:00Z", zipEpoch},
+		{"soon", "1980-01-01T00:00:00Z", zipEpoch},
+	}
+	for _, tt := range tests {
+		t.Setenv("SOURCE_DATE_EPOCH", tt.epoch)
+		b := reproducibleBook(t, nil)
+		opf := documents(t, b)["OEBPS/content.opf"]
+		if !strings.Contains(opf, `<meta property="dcterms:modified">`+tt.modified+"</meta>") {
+			t.Errorf("SOURCE_DATE_EPOCH=%s: content.opf isn't modified %s", tt.epoch, tt.modified)
+		}
+		for _, zf := range readEpub(t, b).File {
+			if !zf.Modified.Equal(tt.zip) {
+				t.Errorf("SOURCE_DATE_EPOCH=%s: %s was modified %v, want %v", tt.epoch, zf.Name, zf.Modified, tt.zip)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Package ruby adds furigana to goldmark using the Denden Markdown
+// syntax. {漢字|かんじ} annotates the whole word, and {漢字|かん|じ}
+// annotates each character on its own.
+package ruby
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var (
+	KindRuby  = ast.NewNodeKind("Ruby")
+	rubyRegex = regexp.MustCompile(`^\{([^{}|]+)\|([^{}]+)\}`)
+)
+
+type rubyAST struct {
+	ast.BaseInline
+	Base     []string
+	Readings []string
+}
+
+func (r *rubyAST) Dump(source []byte, level int) {
+	ast.DumpHelper(r, source, level, map[string]string{
+		"Base":     strings.Join(r.Base, ""),
+		"Readings": strings.Join(r.Readings, "|"),
+	}, nil)
+}
+
+func (r *rubyAST) Kind() ast.NodeKind {
+	return KindRuby
+}
+
+type rubyParser struct{}
+
+func (p *rubyParser) Trigger() []byte {
+	return []byte{'{'}
+}
+
+func (p *rubyParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	m := rubyRegex.FindSubmatch(line)
+	if m == nil {
+		return nil
+	}
+	base := string(m[1])
+	readings := strings.Split(string(m[2]), "|")
+	n := &rubyAST{
+		Base:     []string{base},
+		Readings: []string{strings.Join(readings, "")},
+	}
+	// one reading per character annotates each character
+	if len(readings) > 1 && len(readings) == utf8.RuneCountInString(base) {
+		n.Base = strings.Split(base, "")
+		n.Readings = readings
+	}
+	block.Advance(len(m[0]))
+	return n
+}
+
+type rubyHTMLRenderer struct{}
+
+func (r *rubyHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindRuby, r.renderRuby)
+}
+
+func (r *rubyHTMLRenderer) renderRuby(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*rubyAST)
+	w.WriteString("<ruby>")
+	for i := range n.Base {
+		w.Write(util.EscapeHTML([]byte(n.Base[i])))
+		w.WriteString("<rp>(</rp><rt>")
+		w.Write(util.EscapeHTML([]byte(n.Readings[i])))
+		w.WriteString("</rt><rp>)</rp>")
+	}
+	w.WriteString("</ruby>")
+	return ast.WalkSkipChildren, nil
+}
+
+var Extension = &ruby{}
+
+type ruby struct{}
+
+func (r *ruby) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(&rubyParser{}, 100),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&rubyHTMLRenderer{}, 500),
+	))
+}
@@ -0,0 +1,71 @@
+package epub
+
+import (
+	"fmt"
+	"html"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	RegisterShortcode("image", imageShortcode)
+}
+
+// inParagraph is the attribute set on shortcodes directly inside
+// a paragraph.
+const inParagraph = "cahaba-in-paragraph"
+
+// imageSizes are the sizes of the image shortcode, each with its
+// own cahaba--<size>-image class in default.css.
+var imageSizes = map[string]bool{
+	"page":   true,
+	"width":  true,
+	"normal": true,
+	"narrow": true,
+}
+
+// imageShortcode renders {{< image src="map.png" size="width"
+// caption="The map" alt="..." >}} as a figure. src is resolved like
+// a markdown image, size defaults to normal, and page images are
+// put on a page of their own.
+func imageShortcode(e *Book, _ string, attrs map[string]any, _ string) (string, error) {
+	src, _ := attrs["src"].(string)
+	size, _ := attrs["size"].(string)
+	caption, _ := attrs["caption"].(string)
+	alt, ok := attrs["alt"].(string)
+	if !ok {
+		alt = caption
+	}
+	if src == "" {
+		return "", errors.New("image shortcode needs a src")
+	}
+	if size == "" {
+		size = "normal"
+	}
+	if !imageSizes[size] {
+		return "", errors.Errorf("image shortcode size must be page, width, normal, or narrow, not %q", size)
+	}
+	resolved, err := e.resolveImage(src)
+	if err != nil {
+		return "", err
+	}
+
+	figure := fmt.Sprintf(
+		`<figure class="cahaba--figure cahaba--%s-figure"><img src="%s" alt="%s" class="cahaba--%s-image" />`,
+		size, html.EscapeString(resolved), html.EscapeString(alt), size,
+	)
+	if caption != "" {
+		figure += "<figcaption>" + html.EscapeString(caption) + "</figcaption>"
+	}
+	figure += "</figure>"
+
+	if size == "page" {
+		figure = "<!-- PAGE BREAK -->" + figure + "<!-- PAGE BREAK -->"
+	}
+	// figures can't be inside the paragraph the shortcode is in,
+	// but can be inside list items and table cells
+	if attrs[inParagraph] == true {
+		return "</p>" + figure + "<p>", nil
+	}
+	return figure, nil
+}
@@ -0,0 +1,88 @@
+package epub
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// shortcodePages renders body as a chapter and returns its pages.
+func shortcodePages(t *testing.T, body string) []string {
+	t.Helper()
+	e := NewBook("Test Book")
+	must(t, e.AddImage("testdata/gophercolor16x16.png", "gopher.png"))
+	must(t, e.AddChapterMD("One", body))
+	docs := documents(t, buildValid(t, e))
+	pages := []string{}
+	for i := 0; ; i++ {
+		doc, ok := docs[fmt.Sprintf("OEBPS/text/chapter001-%d.xhtml", i)]
+		if !ok {
+			return pages
+		}
+		main := doc[strings.Index(doc, `<div class="cahaba--main">`):strings.LastIndex(doc, "</div>")]
+		pages = append(pages, strings.Join(strings.Fields(main), " "))
+	}
+}
+
+func TestImageShortcode(t *testing.T) {
+	figure := `<figure class="cahaba--figure cahaba--%s-figure"><img src="../images/img_gopher.png" alt="A &#34;gopher&#34;" class="cahaba--%s-image" /><figcaption>A &lt;gopher&gt;</figcaption></figure>`
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			name: "own paragraph",
+			body: `{{< image src="gopher.png" size="width" caption="A <gopher>" alt='A "gopher"' >}}`,
+			want: []string{`<div class="cahaba--main"> <h1 class="cahaba--title">One</h1> ` + fmt.Sprintf(figure, "width", "width")},
+		},
+		{
+			name: "inside a paragraph",
+			body: `Before {{< image src="gopher.png" caption="A <gopher>" alt='A "gopher"' >}} after.`,
+			want: []string{`<p>Before </p>` + fmt.Sprintf(figure, "normal", "normal") + `<p> after.</p>`},
+		},
+		{
+			name: "in a list",
+			body: "- An item\n- {{< image src=\"gopher.png\" caption=\"A <gopher>\" alt='A \"gopher\"' >}}\n",
+			want: []string{`<li>` + fmt.Sprintf(figure, "normal", "normal") + `</li>`},
+		},
+		{
+			name: "page inside a paragraph",
+			body: `Before {{< image src="gopher.png" size="page" caption="A <gopher>" alt='A "gopher"' >}} after.`,
+			want: []string{
+				`<p>Before </p>`,
+				fmt.Sprintf(figure, "page", "page"),
+				`<p> after.</p>`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages := shortcodePages(t, tt.body)
+			if len(pages) != len(tt.want) {
+				t.Fatalf("Got %d pages, want %d:\n%s", len(pages), len(tt.want), strings.Join(pages, "\n"))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(pages[i], want) {
+					t.Errorf("Page %d is\n%s\nwant it to contain\n%s", i, pages[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestImageShortcodeErrors(t *testing.T) {
+	for _, body := range []string{
+		`{{< image caption="No source" >}}`,
+		`{{< image src="gopher.png" size="huge" >}}`,
+		`{{< image src="missing.png" >}}`,
+	} {
+		e := NewBook("Test Book")
+		must(t, e.AddImage("testdata/gophercolor16x16.png", "gopher.png"))
+		if err := e.AddChapterMD("One", body); err == nil {
+			if _, err := e.Bytes(); err == nil {
+				t.Errorf("%s rendered without an error", body)
+			}
+		}
+	}
+}
@@ -31,8 +31,11 @@ func RetrieveTemplate(filename string) ([]byte, error) {
 }
 
 // OverrideTemplate will set a new template for the filename.
-// Valid filenames are book.opf, chapter.xhtml, container.xml,
-// cover.xhtml, main.css, toc.ncx, and toc.xhtml.
+// Valid filenames are content.opf, chapter.xhtml, part.xhtml,
+// titlepage.xhtml, copyright.xhtml, dedication.xhtml, epigraph.xhtml,
+// alsoby.xhtml, aboutauthor.xhtml, newsletter.xhtml, container.xml,
+// cover.xhtml, default.css, vertical.css, toc.ncx, nav.xhtml, and
+// contents.xhtml.
 func OverrideTemplate(filename string, content []byte) {
 	overrides[filename] = content
 }
@@ -47,6 +50,7 @@ func CompileTemplate(filename string) (*template.Template, error) {
 			"clean": func(s, cutset string) string {
 				return strings.TrimPrefix(s, cutset)
 			},
+			"xml": xmlEscape,
 		}).Parse(string(b))
 	if err != nil {
 		return nil, errors.Wrap(
@@ -0,0 +1,201 @@
+package epub
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	headingRegex = regexp.MustCompile(`(?s)<h([1-6])([^>]*)>(.*?)</h[1-6]>`)
+	idAttrRegex  = regexp.MustCompile(`\bid="([^"]*)"`)
+	tagRegex     = regexp.MustCompile(`<[^>]*>`)
+)
+
+// SetTOCDepth sets how many heading levels are listed in the table
+// of contents. The default of 1 lists only the chapters, 2 adds the
+// h2 headings inside each chapter, and 3 adds h3 headings under
+// those. Headings without an id are given one based on their text.
+func (e *Book) SetTOCDepth(depth int) {
+	e.tocDepth = depth
+}
+
+// nextNavPoint returns the next unique navPoint id for toc.ncx.
+func (e *Book) nextNavPoint() string {
+	e.navPoint++
+	return fmt.Sprintf("navPoint%d", e.navPoint+1)
+}
+
+// tocHeadings finds the h2 and h3 headings in content, gives them
+// ids, and adds them to chapter as nested table of contents entries.
+func (e *Book) tocHeadings(content, filename string, chapter *bookChapter) string {
+	if e.tocDepth < 2 {
+		return content
+	}
+	used := make(map[string]bool)
+	for _, m := range idAttrRegex.FindAllStringSubmatch(content, -1) {
+		used[m[1]] = true
+	}
+
+	var parent *bookChapter
+	return headingRegex.ReplaceAllStringFunc(content, func(h string) string {
+		m := headingRegex.FindStringSubmatch(h)
+		level := int(m[1][0] - '0')
+		if level < 2 || level > e.tocDepth {
+			return h
+		}
+		attrs, inner := m[2], m[3]
+		// the templates escape titles, so entities are undone here
+		title := strings.TrimSpace(html.UnescapeString(tagRegex.ReplaceAllString(inner, "")))
+		id := ""
+		if idm := idAttrRegex.FindStringSubmatch(attrs); idm != nil {
+			id = idm[1]
+		} else {
+			id = uniqueID(slugify(title), used)
+			h = fmt.Sprintf(`<h%d id="%s"%s>%s</h%d>`, level, id, attrs, inner, level)
+		}
+
+		siblings := &chapter.Children
+		if level == 3 && parent != nil {
+			siblings = &parent.Children
+		}
+		*siblings = append(*siblings, bookChapter{
+			NavPoint: e.nextNavPoint(),
+			ID:       fmt.Sprintf("%s-%d", chapter.ID, e.navPoint),
+			Title:    title,
+			Path:     "OEBPS/text/" + filename + "#" + id,
+			Type:     "heading",
+		})
+		if level == 2 {
+			parent = &chapter.Children[len(chapter.Children)-1]
+		}
+		return h
+	})
+}
+
+// tocDepth returns how deeply nested the chapters are.
+func tocDepth(chapters []bookChapter) int {
+	depth := 0
+	for _, c := range chapters {
+		if d := tocDepth(c.Children) + 1; d > depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
+// slugify turns a title into a lowercase id made of letters,
+// digits, and dashes.
+func slugify(s string) string {
+	b := &strings.Builder{}
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	if b.Len() == 0 {
+		return "section"
+	}
+	return b.String()
+}
+
+// uniqueID returns id, with a number added if it is already in
+// used, and marks the result as used.
+func uniqueID(id string, used map[string]bool) string {
+	final := id
+	for i := 2; used[final]; i++ {
+		final = fmt.Sprintf("%s-%d", id, i)
+	}
+	used[final] = true
+	return final
+}
+
+// TOCPlacement is where the table of contents is in the reading
+// order.
+type TOCPlacement string
+
+const (
+	// TOCAfterCover places the table of contents right after the
+	// cover.
+	TOCAfterCover TOCPlacement = ""
+	// TOCAfterFrontMatter places it after the introduction
+	// sections, just before the first chapter.
+	TOCAfterFrontMatter TOCPlacement = "after-front-matter"
+	// TOCHidden leaves it out of the reading order. Readers still
+	// show it from their menus.
+	TOCHidden TOCPlacement = "hidden"
+)
+
+// TOCOptions controls the table of contents pages.
+type TOCOptions struct {
+	Placement TOCPlacement
+	// ContentsPage adds a printed style Contents page, using the
+	// contents.xhtml template, in place of nav.xhtml in the reading
+	// order. nav.xhtml is then only shown from the readers' menus.
+	ContentsPage bool
+	// Format sets the table of contents entry of each chapter from
+	// its number, counting only chapters, and its title (Optional)
+	Format func(number int, title string) string
+}
+
+// SetTOC sets where the table of contents is placed and how its
+// chapters are listed.
+func (e *Book) SetTOC(opts TOCOptions) error {
+	switch opts.Placement {
+	case TOCAfterCover, TOCAfterFrontMatter, TOCHidden:
+	default:
+		return errors.Errorf("Invalid TOC placement: %q", opts.Placement)
+	}
+	e.toc = opts
+	return nil
+}
+
+// formatTOC applies the TOC format function to the chapters.
+func (e *Book) formatTOC(chapters []bookChapter, number *int) {
+	for i := range chapters {
+		if chapters[i].Type == "chapter" {
+			*number++
+			chapters[i].Title = e.toc.Format(*number, chapters[i].Title)
+		}
+		e.formatTOC(chapters[i].Children, number)
+	}
+}
+
+// placeTOC writes the Contents page if there is one, and adds it and
+// nav.xhtml to the spine where the placement puts them.
+func (e *Book) placeTOC() error {
+	linear := "yes"
+	if e.toc.Placement == TOCHidden {
+		linear = "no"
+	}
+	refs := []bookSection{{Ref: "nav", Linear: linear}}
+	if e.toc.ContentsPage {
+		if err := e.execTemplate("contents.xhtml", "OEBPS/text/contents.xhtml", mtXHTML); err != nil {
+			return err
+		}
+		refs = []bookSection{
+			{Ref: "contents.xhtml", Linear: linear},
+			{Ref: "nav", Linear: "no"},
+		}
+	}
+
+	at := 0
+	if e.toc.Placement == TOCAfterFrontMatter {
+		at = e.frontPages
+	}
+	sections := append([]bookSection{}, e.args.Sections[:at]...)
+	sections = append(sections, refs...)
+	e.args.Sections = append(sections, e.args.Sections[at:]...)
+	return nil
+}
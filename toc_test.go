@@ -0,0 +1,160 @@
+package epub
+
+import (
+	"encoding/xml"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Hello World", "hello-world"},
+		{"  Tom & Jerry!  ", "tom-jerry"},
+		{"Chapter 1: The Start", "chapter-1-the-start"},
+		{"Über Straße", "über-straße"},
+		{"第一章", "第一章"},
+		{"?!", "section"},
+		{"", "section"},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.in); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUniqueID(t *testing.T) {
+	used := map[string]bool{"intro": true}
+	for _, want := range []string{"intro-2", "intro-3", "notes"} {
+		id := "intro"
+		if want == "notes" {
+			id = "notes"
+		}
+		if got := uniqueID(id, used); got != want {
+			t.Errorf("uniqueID(%q) = %q, want %q", id, got, want)
+		}
+	}
+	if !used["intro-2"] || !used["intro-3"] || !used["notes"] {
+		t.Errorf("uniqueID didn't mark its ids as used: %v", used)
+	}
+}
+
+func TestTOCHeadings(t *testing.T) {
+	e := NewBook("Test Book")
+	e.SetTOCDepth(3)
+	must(t, e.AddChapterMD("One", `Text.
+
+## Tom & Jerry
+
+More.
+
+### \<Cats\> & Mice
+
+Even more.
+
+## Tom & Jerry
+
+Again.`))
+	docs := documents(t, buildValid(t, e))
+
+	chapter := ""
+	for name, doc := range docs {
+		if strings.Contains(doc, "Even more.") {
+			chapter = name
+		}
+	}
+	for _, want := range []string{`<h2 id="tom-jerry">`, `<h3 id="cats-mice">`, `<h2 id="tom-jerry-2">`} {
+		if !strings.Contains(docs[chapter], want) {
+			t.Errorf("%s is missing %s", chapter, want)
+		}
+	}
+	for _, name := range []string{"OEBPS/text/nav.xhtml", "OEBPS/toc.ncx"} {
+		doc := docs[name]
+		if strings.Contains(doc, "&amp;amp;") || strings.Contains(doc, "&amp;lt;") {
+			t.Errorf("%s escapes heading titles twice:\n%s", name, doc)
+		}
+		for _, want := range []string{"Tom &amp; Jerry", "&lt;Cats&gt; &amp; Mice"} {
+			if !strings.Contains(doc, want) {
+				t.Errorf("%s is missing %q", name, want)
+			}
+		}
+	}
+}
+
+// spine returns the hrefs of the linear documents in the reading
+// order of content.opf.
+func spine(t *testing.T, opf string) []string {
+	t.Helper()
+	pkg := opfPackage{}
+	must(t, xml.Unmarshal([]byte(opf), &pkg))
+	hrefs := make(map[string]string)
+	for _, item := range pkg.Manifest {
+		hrefs[item.ID] = path.Base(item.Href)
+	}
+	refs := []string{}
+	for _, ref := range pkg.Spine.Itemrefs {
+		if ref.Linear != "no" {
+			refs = append(refs, hrefs[ref.IDRef])
+		}
+	}
+	return refs
+}
+
+func TestPlaceTOCWithParts(t *testing.T) {
+	tests := []struct {
+		opts TOCOptions
+		// want is the index of the table of contents in the reading
+		// order, after the cover, or -1 when it's hidden
+		want     int
+		contents string
+	}{
+		{TOCOptions{Placement: TOCAfterCover}, 1, "nav.xhtml"},
+		{TOCOptions{Placement: TOCAfterFrontMatter}, 2, "nav.xhtml"},
+		{TOCOptions{Placement: TOCAfterFrontMatter, ContentsPage: true}, 2, "contents.xhtml"},
+		{TOCOptions{Placement: TOCHidden}, -1, "nav.xhtml"},
+	}
+	for _, tt := range tests {
+		e := NewBook("Test Book")
+		must(t, e.SetTOC(tt.opts))
+		must(t, e.AddIntroductionMD("Foreword", "Before."))
+		must(t, e.AddPart("The Return", PartOptions{Label: "Part One"}))
+		must(t, e.AddChapterMD("One", "First."))
+		must(t, e.AddChapterMD("Two", "Second."))
+		must(t, e.AddPart("The End", PartOptions{Label: "Part Two"}))
+		must(t, e.AddChapterMD("Three", "Third."))
+		docs := documents(t, buildValid(t, e))
+
+		refs := spine(t, docs["OEBPS/content.opf"])
+		if len(refs) != 8 && !(tt.want == -1 && len(refs) == 7) {
+			t.Fatalf("%+v: reading order %v", tt.opts, refs)
+		}
+		at := -1
+		for i, ref := range refs {
+			if ref == tt.contents {
+				at = i
+			}
+		}
+		if at != tt.want {
+			t.Errorf("%+v: %s is at %d of %v, want %d", tt.opts, tt.contents, at, refs, tt.want)
+		}
+		if tt.want != -1 && refs[tt.want+1] == "nav.xhtml" {
+			t.Errorf("%+v: nav.xhtml follows the Contents page in the reading order", tt.opts)
+		}
+
+		// the chapters are nested under their parts
+		nav := docs["OEBPS/text/nav.xhtml"]
+		nav = nav[strings.Index(nav, `epub:type="toc"`):strings.Index(nav, "</nav>")]
+		order := []string{"The Return", "<ol", "One", "Two", "</ol>", "The End", "<ol", "Three", "</ol>"}
+		rest := nav
+		for _, s := range order {
+			i := strings.Index(rest, s)
+			if i < 0 {
+				t.Fatalf("%+v: nav.xhtml doesn't nest the chapters under their parts:\n%s", tt.opts, nav)
+			}
+			rest = rest[i+len(s):]
+		}
+	}
+}
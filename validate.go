@@ -0,0 +1,456 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Severity is how serious a ValidationIssue is.
+type Severity int
+
+const (
+	// SeverityError issues make the epub invalid and will be
+	// rejected by retailers.
+	SeverityError Severity = iota
+	// SeverityWarning issues are allowed by the spec but are
+	// likely to display badly in some readers.
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "ERROR"
+	case SeverityWarning:
+		return "WARNING"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// ValidationIssue is a single problem found by Validate. Line is 0
+// when the issue applies to the whole file, and File is empty when
+// it applies to the whole epub.
+type ValidationIssue struct {
+	Severity Severity
+	File     string
+	Line     int
+	Message  string
+}
+
+func (v ValidationIssue) String() string {
+	switch {
+	case v.File == "":
+		return fmt.Sprintf("%s: %s", v.Severity, v.Message)
+	case v.Line == 0:
+		return fmt.Sprintf("%s %s: %s", v.Severity, v.File, v.Message)
+	}
+	return fmt.Sprintf("%s %s:%d: %s", v.Severity, v.File, v.Line, v.Message)
+}
+
+// Validate builds the book and checks the result with the same
+// rules as ValidateFile. The book can still be changed afterwards,
+// to fix what was found before it's written.
+func (e *Book) Validate() []ValidationIssue {
+	buf := &bytes.Buffer{}
+	if _, err := e.WriteTo(buf); err != nil {
+		return []ValidationIssue{{
+			Severity: SeverityError,
+			Message:  err.Error(),
+		}}
+	}
+	issues, err := ValidateReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		return []ValidationIssue{{
+			Severity: SeverityError,
+			Message:  err.Error(),
+		}}
+	}
+	return issues
+}
+
+// ValidateFile checks a finished epub. The checks follow the
+// epubcheck rules that retailers most often reject books for:
+// the mimetype entry, the manifest and spine, required metadata,
+// the cover image, well-formed XHTML, unique ids, and image and
+// link targets.
+func ValidateFile(filename string) ([]ValidationIssue, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, &FileRetrievalError{Source: filename, Err: err}
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, &FileRetrievalError{Source: filename, Err: err}
+	}
+	return ValidateReader(f, info.Size())
+}
+
+// ValidateReader checks an epub of the given size read from r.
+// An error is only returned when r isn't a zip file at all.
+func ValidateReader(r io.ReaderAt, size int64) ([]ValidationIssue, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "Read epub")
+	}
+	v := &validator{
+		docs: make(map[string]*validatedDoc),
+	}
+	v.checkMimetype(zr)
+	a, err := openArchive(zr)
+	if err != nil {
+		v.add(SeverityError, "", 0, err.Error())
+		return v.issues, nil
+	}
+	v.epubArchive = a
+	v.checkMetadata()
+	v.checkManifest()
+	v.checkSpine()
+	v.checkCover()
+	v.checkDocuments()
+	return v.issues, nil
+}
+
+type validator struct {
+	*epubArchive
+	issues []ValidationIssue
+	docs   map[string]*validatedDoc
+}
+
+// validatedDoc is what the validator learned from one XHTML file.
+type validatedDoc struct {
+	ids  map[string]bool
+	refs []validatedRef
+}
+type validatedRef struct {
+	element string
+	target  string
+	line    int
+}
+
+func (v *validator) add(severity Severity, file string, line int, format string, args ...any) {
+	v.issues = append(v.issues, ValidationIssue{
+		Severity: severity,
+		File:     file,
+		Line:     line,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) checkMimetype(zr *zip.Reader) {
+	if len(zr.File) == 0 || zr.File[0].Name != "mimetype" {
+		v.add(SeverityError, "mimetype", 0, "mimetype must be the first file in the epub")
+		for _, zf := range zr.File {
+			if zf.Name == "mimetype" {
+				v.checkMimetypeEntry(zf)
+			}
+		}
+		return
+	}
+	v.checkMimetypeEntry(zr.File[0])
+}
+
+func (v *validator) checkMimetypeEntry(zf *zip.File) {
+	if zf.Method != zip.Store {
+		v.add(SeverityError, "mimetype", 0, "mimetype must be stored without compression")
+	}
+	if len(zf.Extra) > 0 {
+		v.add(SeverityError, "mimetype", 0, "mimetype must not have extra fields")
+	}
+	r, err := zf.Open()
+	if err != nil {
+		v.add(SeverityError, "mimetype", 0, "Can't read mimetype: %v", err)
+		return
+	}
+	defer r.Close()
+	b, _ := io.ReadAll(r)
+	if string(b) != "application/epub+zip" {
+		v.add(SeverityError, "mimetype", 0, "mimetype must contain exactly application/epub+zip, found %q", string(b))
+	}
+}
+
+func (v *validator) checkMetadata() {
+	md := v.pkg.Metadata
+	if v.pkg.UniqueIdentifier == "" {
+		v.add(SeverityError, v.opfPath, lineOf(v.opf, "<package"), "package is missing the unique-identifier attribute")
+	} else {
+		found := false
+		for _, id := range md.Identifiers {
+			if id.ID == v.pkg.UniqueIdentifier {
+				found = true
+				if strings.TrimSpace(id.Value) == "" {
+					v.add(SeverityError, v.opfPath, lineOf(v.opf, "<dc:identifier"), "dc:identifier is empty")
+				}
+			}
+		}
+		if !found {
+			v.add(SeverityError, v.opfPath, lineOf(v.opf, "<package"), "unique-identifier %q doesn't match any dc:identifier", v.pkg.UniqueIdentifier)
+		}
+	}
+
+	required := []struct {
+		name     string
+		elements []opfElement
+	}{
+		{"dc:title", md.Titles},
+		{"dc:language", md.Languages},
+	}
+	for _, r := range required {
+		if len(r.elements) == 0 {
+			v.add(SeverityError, v.opfPath, lineOf(v.opf, "<metadata"), "metadata is missing %s", r.name)
+			continue
+		}
+		for _, el := range r.elements {
+			if strings.TrimSpace(el.Value) == "" {
+				v.add(SeverityError, v.opfPath, lineOf(v.opf, "<"+r.name), "%s is empty", r.name)
+			}
+		}
+	}
+	for _, el := range md.Languages {
+		if lang := strings.TrimSpace(el.Value); lang != "" && !languageTagRegex.MatchString(lang) {
+			v.add(SeverityWarning, v.opfPath, lineOf(v.opf, "<dc:language"), "dc:language %q isn't a BCP 47 language tag", lang)
+		}
+	}
+
+	modified := false
+	for _, m := range md.Metas {
+		if m.Property == "dcterms:modified" && m.Refines == "" {
+			modified = true
+		}
+	}
+	if !modified {
+		v.add(SeverityError, v.opfPath, lineOf(v.opf, "<metadata"), "metadata is missing dcterms:modified")
+	}
+	if len(md.Creators) == 0 {
+		v.add(SeverityWarning, v.opfPath, lineOf(v.opf, "<metadata"), "metadata has no dc:creator")
+	}
+}
+
+func (v *validator) checkManifest() {
+	ids := make(map[string]bool)
+	nav := false
+	for _, item := range v.pkg.Manifest {
+		line := lineOf(v.opf, `id="`+item.ID+`"`)
+		if ids[item.ID] {
+			v.add(SeverityError, v.opfPath, line, "duplicate manifest id %q", item.ID)
+		}
+		ids[item.ID] = true
+		if hasProperty(item.Properties, "nav") {
+			nav = true
+		}
+		p := v.itemPath(item)
+		if _, ok := v.files[p]; !ok {
+			v.add(SeverityError, v.opfPath, line, "manifest item %q points at missing file %s", item.ID, p)
+		}
+		if item.MediaType == "" {
+			v.add(SeverityError, v.opfPath, line, "manifest item %q has no media-type", item.ID)
+		}
+	}
+	if !nav {
+		v.add(SeverityError, v.opfPath, lineOf(v.opf, "<manifest"), "manifest has no item with the nav property")
+	}
+}
+
+func (v *validator) checkSpine() {
+	if len(v.pkg.Spine.Itemrefs) == 0 {
+		v.add(SeverityError, v.opfPath, lineOf(v.opf, "<spine"), "spine is empty")
+	}
+	for _, ref := range v.pkg.Spine.Itemrefs {
+		if _, ok := v.item(ref.IDRef); !ok {
+			v.add(SeverityError, v.opfPath, lineOf(v.opf, `idref="`+ref.IDRef+`"`), "spine itemref %q doesn't match a manifest item", ref.IDRef)
+		}
+	}
+	if v.pkg.Spine.Toc != "" {
+		if _, ok := v.item(v.pkg.Spine.Toc); !ok {
+			v.add(SeverityError, v.opfPath, lineOf(v.opf, "<spine"), "spine toc %q doesn't match a manifest item", v.pkg.Spine.Toc)
+		}
+	}
+}
+
+// Retailer cover sizes. Apple Books asks for covers at least 1400
+// pixels wide, and most stores expect a portrait cover about 1.6
+// times as tall as it is wide.
+const (
+	coverMinWidth = 1400
+	coverMinRatio = 1.3
+	coverMaxRatio = 1.8
+)
+
+// checkCover checks the epub2 cover meta, and that the cover image
+// is a format and size retailers accept.
+func (v *validator) checkCover() {
+	for _, m := range v.pkg.Metadata.Metas {
+		if m.Name != "cover" {
+			continue
+		}
+		line := lineOf(v.opf, `name="cover"`)
+		if _, ok := v.item(m.Content); !ok {
+			v.add(SeverityWarning, v.opfPath, line, "cover meta %q must be the manifest id of the cover image", m.Content)
+		}
+	}
+
+	item, ok := v.item(v.coverID())
+	if !ok {
+		v.add(SeverityWarning, v.opfPath, lineOf(v.opf, "<manifest"), "manifest has no cover image")
+		return
+	}
+	p := v.itemPath(item)
+	if !coverMediaTypes[item.MediaType] {
+		v.add(SeverityWarning, p, 0, "cover is %s, retailers ask for a JPEG or PNG", item.MediaType)
+		return
+	}
+	b, err := v.read(p)
+	if err != nil {
+		return
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		v.add(SeverityError, p, 0, "Can't read cover image: %v", err)
+		return
+	}
+	if cfg.Width < coverMinWidth {
+		v.add(SeverityWarning, p, 0, "cover is %dx%d, retailers ask for at least %d pixels wide", cfg.Width, cfg.Height, coverMinWidth)
+	}
+	if ratio := float64(cfg.Height) / float64(cfg.Width); ratio < coverMinRatio || ratio > coverMaxRatio {
+		v.add(
+			SeverityWarning, p, 0, "cover is %dx%d, its height should be %.1f to %.1f times its width",
+			cfg.Width, cfg.Height, coverMinRatio, coverMaxRatio,
+		)
+	}
+}
+
+// checkDocuments parses every XHTML file in the manifest, then
+// checks the image and link targets once all ids are known.
+func (v *validator) checkDocuments() {
+	paths := []string{}
+	for _, item := range v.pkg.Manifest {
+		if item.MediaType != mtXHTML {
+			continue
+		}
+		p := v.itemPath(item)
+		b, err := v.read(p)
+		if err != nil {
+			continue
+		}
+		v.docs[p] = v.parseDocument(p, b)
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	manifest := make(map[string]bool)
+	for _, item := range v.pkg.Manifest {
+		manifest[v.itemPath(item)] = true
+	}
+	for _, p := range paths {
+		for _, ref := range v.docs[p].refs {
+			v.checkRef(p, ref, manifest)
+		}
+	}
+}
+
+func (v *validator) checkRef(from string, ref validatedRef, manifest map[string]bool) {
+	if strings.Contains(ref.target, ":") && !strings.HasPrefix(ref.target, "#") {
+		// external links can't be checked
+		return
+	}
+	target := from
+	if !strings.HasPrefix(ref.target, "#") {
+		target = resolveRef(from, ref.target)
+	}
+	if target == "" {
+		v.add(SeverityError, from, ref.line, "<%s> has an empty target", ref.element)
+		return
+	}
+	if _, ok := v.files[target]; !ok {
+		v.add(SeverityError, from, ref.line, "<%s> target %s doesn't exist", ref.element, ref.target)
+		return
+	}
+	if !manifest[target] {
+		v.add(SeverityError, from, ref.line, "<%s> target %s isn't listed in the manifest", ref.element, ref.target)
+		return
+	}
+	_, fragment, ok := strings.Cut(ref.target, "#")
+	if !ok || fragment == "" {
+		return
+	}
+	doc, ok := v.docs[target]
+	if !ok {
+		return
+	}
+	if !doc.ids[fragment] {
+		v.add(SeverityError, from, ref.line, "<%s> target %s has no element with id %q", ref.element, target, fragment)
+	}
+}
+
+// parseDocument checks that b is well-formed XML with unique ids
+// and collects its ids, images, and links.
+func (v *validator) parseDocument(p string, b []byte) *validatedDoc {
+	doc := &validatedDoc{
+		ids: make(map[string]bool),
+	}
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = true
+	for {
+		offset := d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			line := lineAt(b, d.InputOffset())
+			if se, ok := err.(*xml.SyntaxError); ok {
+				line = se.Line
+			}
+			v.add(SeverityError, p, line, "XHTML isn't well-formed: %v", err)
+			break
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		line := lineAt(b, offset)
+		for _, a := range start.Attr {
+			switch {
+			case a.Name.Local == "id":
+				if doc.ids[a.Value] {
+					v.add(SeverityError, p, line, "duplicate id %q", a.Value)
+				}
+				doc.ids[a.Value] = true
+			case start.Name.Local == "img" && a.Name.Local == "src",
+				start.Name.Local == "a" && a.Name.Local == "href":
+				doc.refs = append(doc.refs, validatedRef{
+					element: start.Name.Local,
+					target:  a.Value,
+					line:    line,
+				})
+			}
+		}
+	}
+	return doc
+}
+
+// lineAt returns the 1 indexed line of offset in b.
+func lineAt(b []byte, offset int64) int {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	return bytes.Count(b[:offset], []byte{'\n'}) + 1
+}
+
+// lineOf returns the line of the first occurrence of s in b, or 0.
+func lineOf(b []byte, s string) int {
+	i := bytes.Index(b, []byte(s))
+	if i < 0 {
+		return 0
+	}
+	return lineAt(b, int64(i))
+}
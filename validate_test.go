@@ -0,0 +1,359 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testOPF = `<?xml version="1.0" encoding="utf-8"?>
+<package version="3.0" unique-identifier="id" xmlns="http://www.idpf.org/2007/opf">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="id">urn:uuid:3c0f8a2e-4b5d-4f7c-9e1a-2b3c4d5e6f70</dc:identifier>
+    <dc:title>Elsewhere</dc:title>
+    <dc:language>en</dc:language>
+    <dc:creator>Someone Else</dc:creator>
+    <meta property="dcterms:modified">2020-01-01T00:00:00Z</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ch01" href="ch01.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch02" href="ch02.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="ch01"/>
+    <itemref idref="ch02"/>
+  </spine>
+</package>`
+
+// testBook returns the files of a small epub that wasn't made by
+// this package.
+func testBook() map[string]string {
+	return map[string]string{
+		"OEBPS/content.opf": testOPF,
+		"OEBPS/nav.xhtml": `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>Contents</title></head>
+<body><nav epub:type="toc"><ol>
+  <li><a href="ch01.xhtml">First</a></li>
+  <li><a href="ch02.xhtml">Second</a></li>
+</ol></nav></body>
+</html>`,
+		"OEBPS/ch01.xhtml": `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>First</title></head>
+<body><h1>First</h1><p>See <a href="ch02.xhtml#n1">the note</a>.</p></body>
+</html>`,
+		"OEBPS/ch02.xhtml": `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>Second</title></head>
+<body><h1>Second</h1><p id="n1">The note.</p></body>
+</html>`,
+	}
+}
+
+func TestValidateReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(files map[string]string)
+		want   string
+	}{
+		{
+			name:   "valid",
+			change: func(map[string]string) {},
+		},
+		{
+			name: "missing manifest file",
+			change: func(files map[string]string) {
+				delete(files, "OEBPS/ch02.xhtml")
+			},
+			want: `manifest item "ch02" points at missing file OEBPS/ch02.xhtml`,
+		},
+		{
+			name: "unresolved itemref",
+			change: func(files map[string]string) {
+				files["OEBPS/content.opf"] = strings.Replace(testOPF, `idref="ch02"`, `idref="ch03"`, 1)
+			},
+			want: `spine itemref "ch03" doesn't match a manifest item`,
+		},
+		{
+			name: "missing title",
+			change: func(files map[string]string) {
+				files["OEBPS/content.opf"] = strings.Replace(testOPF, "<dc:title>Elsewhere</dc:title>", "", 1)
+			},
+			want: "metadata is missing dc:title",
+		},
+		{
+			name: "malformed",
+			change: func(files map[string]string) {
+				files["OEBPS/ch02.xhtml"] = strings.Replace(files["OEBPS/ch02.xhtml"], "</p>", "", 1)
+			},
+			want: "OEBPS/ch02.xhtml:4: XHTML isn't well-formed",
+		},
+		{
+			name: "missing image",
+			change: func(files map[string]string) {
+				files["OEBPS/ch02.xhtml"] = strings.Replace(files["OEBPS/ch02.xhtml"], "The note.", `<img src="map.png" alt=""/>`, 1)
+			},
+			want: "<img> target map.png doesn't exist",
+		},
+		{
+			name: "missing fragment",
+			change: func(files map[string]string) {
+				files["OEBPS/ch02.xhtml"] = strings.Replace(files["OEBPS/ch02.xhtml"], `id="n1"`, `id="n2"`, 1)
+			},
+			want: `OEBPS/ch01.xhtml:4: <a> target OEBPS/ch02.xhtml has no element with id "n1"`,
+		},
+		{
+			name: "duplicate id",
+			change: func(files map[string]string) {
+				files["OEBPS/ch02.xhtml"] = strings.Replace(files["OEBPS/ch02.xhtml"], "<h1>", `<h1 id="n1">`, 1)
+			},
+			want: `duplicate id "n1"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := testBook()
+			tt.change(files)
+			b := zipBook(t, files)
+			issues, err := ValidateReader(bytes.NewReader(b), int64(len(b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			errs := []string{}
+			for _, issue := range issues {
+				if issue.Severity == SeverityError {
+					errs = append(errs, issue.String())
+				}
+			}
+			if tt.want == "" {
+				if len(errs) > 0 {
+					t.Errorf("Unexpected errors:\n%s", strings.Join(errs, "\n"))
+				}
+				return
+			}
+			if len(errs) == 0 || !strings.Contains(errs[0], tt.want) {
+				t.Errorf("Got errors:\n%s\nwant %q first", strings.Join(errs, "\n"), tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMimetype(t *testing.T) {
+	tests := []struct {
+		name  string
+		first string
+		entry *zip.FileHeader
+		want  string
+	}{
+		{
+			name:  "not first",
+			first: "META-INF/container.xml",
+			entry: &zip.FileHeader{Name: "mimetype", Method: zip.Store},
+			want:  "mimetype must be the first file in the epub",
+		},
+		{
+			name:  "compressed",
+			entry: &zip.FileHeader{Name: "mimetype", Method: zip.Deflate},
+			want:  "mimetype must be stored without compression",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			zw := zip.NewWriter(buf)
+			if tt.first != "" {
+				w, _ := zw.Create(tt.first)
+				io.WriteString(w, testContainer)
+			}
+			w, err := zw.CreateHeader(tt.entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(w, "application/epub+zip")
+			for name, content := range testBook() {
+				w, _ := zw.Create(name)
+				io.WriteString(w, content)
+			}
+			if tt.first == "" {
+				w, _ := zw.Create("META-INF/container.xml")
+				io.WriteString(w, testContainer)
+			}
+			must(t, zw.Close())
+
+			issues, err := ValidateReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(issues) == 0 || issues[0].Message != tt.want {
+				t.Errorf("Got %v, want %q first", issues, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateLeavesBook(t *testing.T) {
+	e := NewBook("Test Book")
+	e.SetModifiedTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	must(t, e.AddChapterMD("One", "The first chapter."))
+	for _, issue := range e.Validate() {
+		if issue.Severity == SeverityError {
+			t.Error(issue)
+		}
+	}
+
+	// changes made after validating are in the written book
+	e.SetAuthor("Test Author")
+	must(t, e.AddChapterMD("Two", "The second chapter."))
+	b := buildValid(t, e)
+	wantText(t, b, []string{"Test Author", "The second chapter."})
+	docs := documents(t, b)
+	if n := strings.Count(docs["OEBPS/content.opf"], `<itemref idref="chapter`); n != 2 {
+		t.Errorf("Built %d chapters, want 2", n)
+	}
+
+	// building again gives the same book
+	again := buildValid(t, e)
+	if !bytes.Equal(documentsText(t, b), documentsText(t, again)) {
+		t.Error("Second build differs from the first")
+	}
+}
+
+func TestBuildErrorLeavesBook(t *testing.T) {
+	e := NewBook("Test Book")
+	must(t, e.AddChapterMD("One", "See [the end](#chapter:missing)."))
+	if _, err := e.Bytes(); err == nil {
+		t.Fatal("Built a book with an unresolved link")
+	}
+	if _, err := e.Bytes(); err == nil {
+		t.Fatal("Second build of a book with an unresolved link succeeded")
+	}
+}
+
+// buildValid builds the book and fails the test on any validation
+// error.
+func buildValid(t *testing.T, e *Book) []byte {
+	t.Helper()
+	b, err := e.Bytes()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	issues, err := ValidateReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			t.Error(issue)
+		}
+	}
+	return b
+}
+
+// wantText fails the test for each string that isn't in any
+// document of the book.
+func wantText(t *testing.T, b []byte, want []string) {
+	t.Helper()
+	docs := documents(t, b)
+	for _, w := range want {
+		found := false
+		for _, doc := range docs {
+			if strings.Contains(doc, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%q not found in the book", w)
+		}
+	}
+}
+
+// documents returns the text files of a built book by name.
+func documents(t *testing.T, b []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("Read zip: %v", err)
+	}
+	docs := make(map[string]string)
+	for _, zf := range zr.File {
+		switch {
+		case strings.HasSuffix(zf.Name, ".xhtml"), strings.HasSuffix(zf.Name, ".opf"),
+			strings.HasSuffix(zf.Name, ".ncx"), strings.HasSuffix(zf.Name, ".css"):
+		default:
+			continue
+		}
+		r, err := zf.Open()
+		if err != nil {
+			t.Fatalf("Open %s: %v", zf.Name, err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("Read %s: %v", zf.Name, err)
+		}
+		docs[zf.Name] = string(content)
+	}
+	return docs
+}
+
+// documentsText joins the text files of a built book in order.
+func documentsText(t *testing.T, b []byte) []byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("Read zip: %v", err)
+	}
+	docs := documents(t, b)
+	buf := &bytes.Buffer{}
+	for _, zf := range zr.File {
+		buf.WriteString(zf.Name + "\n" + docs[zf.Name])
+	}
+	return buf.Bytes()
+}
+
+const testContainer = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+// zipBook packs files into an epub with the mimetype and container
+// a reader expects.
+func zipBook(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "application/epub+zip")
+	if _, ok := files["META-INF/container.xml"]; !ok {
+		w, _ := zw.Create("META-INF/container.xml")
+		io.WriteString(w, testContainer)
+	}
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, content)
+	}
+	must(t, zw.Close())
+	return buf.Bytes()
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}